@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strconv"
+	"strings"
+)
+
+// Annotations read from a Deployment. Only deployments with the enabled annotation set to "true" are exposed.
+const (
+	annotationPrefix       = "expose.williamnoble.dev/"
+	enabledAnnotation      = annotationPrefix + "enabled"
+	hostAnnotation         = annotationPrefix + "host"
+	pathAnnotation         = annotationPrefix + "path"
+	pathTypeAnnotation     = annotationPrefix + "path-type"
+	ingressClassAnnotation = annotationPrefix + "ingress-class"
+	tlsSecretAnnotation    = annotationPrefix + "tls-secret"
+	portAnnotation         = annotationPrefix + "port"
+	outputAnnotation       = annotationPrefix + "output"
+	gatewayAnnotation      = annotationPrefix + "gateway"
+	tlsIssuerAnnotation    = annotationPrefix + "tls-issuer"
+)
+
+// exposeConfig describes how a deployment should be exposed, built from its annotations.
+type exposeConfig struct {
+	host             string
+	path             string
+	pathType         networkingv1.PathType
+	ingressClassName *string
+	tlsSecret        string
+	// tlsIssuer is the cert-manager issuer of the certificate stored in tlsSecret, when set.
+	tlsIssuer issuerRef
+	// port is the name of the container port to expose, when empty every port is exposed.
+	port string
+	// output and gateway are left empty when not annotated, the controller defaults are used instead.
+	output  string
+	gateway parentGateway
+}
+
+// annotationError is returned for an annotation with a value we can't use, reason is used as the Event reason.
+type annotationError struct {
+	reason     string
+	annotation string
+	msg        string
+}
+
+func (e *annotationError) Error() string {
+	return fmt.Sprintf("annotation %s: %s", e.annotation, e.msg)
+}
+
+// isEnabled reports whether the deployment opted in, a malformed value is reported as an error.
+func isEnabled(dep *appsv1.Deployment) (bool, error) {
+	v, ok := dep.Annotations[enabledAnnotation]
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &annotationError{reason: "InvalidAnnotation", annotation: enabledAnnotation, msg: fmt.Sprintf("%q is not a boolean", v)}
+	}
+	return enabled, nil
+}
+
+// parseExposeConfig builds the exposeConfig for a deployment, defaults are used for missing annotations.
+func parseExposeConfig(dep *appsv1.Deployment) (exposeConfig, error) {
+	cfg := exposeConfig{
+		path:     "/",
+		pathType: networkingv1.PathTypePrefix,
+	}
+	a := dep.Annotations
+
+	if host, ok := a[hostAnnotation]; ok {
+		if errs := validateHost(host); len(errs) > 0 {
+			return cfg, invalid(hostAnnotation, errs)
+		}
+		cfg.host = host
+	}
+
+	if path, ok := a[pathAnnotation]; ok {
+		if !strings.HasPrefix(path, "/") {
+			return cfg, invalid(pathAnnotation, []string{"must be an absolute path"})
+		}
+		cfg.path = path
+	}
+
+	if pathType, ok := a[pathTypeAnnotation]; ok {
+		switch networkingv1.PathType(pathType) {
+		case networkingv1.PathTypePrefix, networkingv1.PathTypeExact, networkingv1.PathTypeImplementationSpecific:
+			cfg.pathType = networkingv1.PathType(pathType)
+		default:
+			return cfg, invalid(pathTypeAnnotation, []string{"must be one of Prefix, Exact or ImplementationSpecific"})
+		}
+	}
+
+	if class, ok := a[ingressClassAnnotation]; ok {
+		if errs := validation.IsDNS1123Subdomain(class); len(errs) > 0 {
+			return cfg, invalid(ingressClassAnnotation, errs)
+		}
+		cfg.ingressClassName = &class
+	}
+
+	if secret, ok := a[tlsSecretAnnotation]; ok {
+		if errs := validation.IsDNS1123Subdomain(secret); len(errs) > 0 {
+			return cfg, invalid(tlsSecretAnnotation, errs)
+		}
+		cfg.tlsSecret = secret
+	}
+
+	if issuer, ok := a[tlsIssuerAnnotation]; ok {
+		parsed, err := parseIssuerRef(issuer)
+		if err != nil {
+			return cfg, invalid(tlsIssuerAnnotation, []string{err.Error()})
+		}
+		if cfg.host == "" {
+			return cfg, invalid(tlsIssuerAnnotation, []string{fmt.Sprintf("requires the %s annotation, the certificate is issued for it", hostAnnotation)})
+		}
+		cfg.tlsIssuer = parsed
+		// the secret is named after the deployment unless tls-secret names it
+		if cfg.tlsSecret == "" {
+			cfg.tlsSecret = dep.Name + "-tls"
+		}
+	}
+
+	if port, ok := a[portAnnotation]; ok {
+		if errs := validation.IsValidPortName(port); len(errs) > 0 {
+			return cfg, invalid(portAnnotation, errs)
+		}
+		if !hasContainerPort(dep, port) {
+			return cfg, &annotationError{reason: "PortNotFound", annotation: portAnnotation, msg: fmt.Sprintf("no container port named %q", port)}
+		}
+		cfg.port = port
+	}
+
+	if output, ok := a[outputAnnotation]; ok {
+		parsed, err := parseOutput(output)
+		if err != nil {
+			return cfg, invalid(outputAnnotation, []string{err.Error()})
+		}
+		cfg.output = parsed
+	}
+
+	if gateway, ok := a[gatewayAnnotation]; ok {
+		parsed, err := parseParentGateway(gateway)
+		if err != nil {
+			return cfg, invalid(gatewayAnnotation, []string{err.Error()})
+		}
+		cfg.gateway = parsed
+	}
+
+	return cfg, nil
+}
+
+func validateHost(host string) []string {
+	if strings.HasPrefix(host, "*.") {
+		return validation.IsWildcardDNS1123Subdomain(host)
+	}
+	return validation.IsDNS1123Subdomain(host)
+}
+
+func invalid(annotation string, errs []string) error {
+	return &annotationError{reason: "InvalidAnnotation", annotation: annotation, msg: strings.Join(errs, ", ")}
+}
+
+func hasContainerPort(dep *appsv1.Deployment, name string) bool {
+	for _, container := range dep.Spec.Template.Spec.Containers {
+		for _, p := range container.Ports {
+			if p.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"strings"
+)
+
+// TLS modes, selected with the --tls-mode flag. Both need cert-manager to be installed.
+const (
+	// tlsModeCertificate generates a Certificate for each deployment.
+	tlsModeCertificate = "certificate"
+	// tlsModeIngressShim annotates the Ingress, cert-manager's ingress-shim then creates the Certificate.
+	tlsModeIngressShim = "ingress-shim"
+)
+
+const certManagerGroup = "cert-manager.io"
+
+// certificateGVR is the Certificate resource of cert-manager, synced through syncUnstructured.
+var certificateGVR = schema.GroupVersionResource{
+	Group:    certManagerGroup,
+	Version:  "v1",
+	Resource: "certificates",
+}
+
+// Ingress annotations read by ingress-shim, we own these on the Ingresses we generate.
+const (
+	issuerIngressAnnotation        = certManagerGroup + "/issuer"
+	clusterIssuerIngressAnnotation = certManagerGroup + "/cluster-issuer"
+)
+
+var ingressShimAnnotations = []string{issuerIngressAnnotation, clusterIssuerIngressAnnotation}
+
+// issuerRef references the cert-manager Issuer or ClusterIssuer which signs a certificate.
+type issuerRef struct {
+	kind string
+	name string
+}
+
+// parseIssuerRef parses an issuer given as "name", an Issuer in the namespace of the deployment, or as
+// "Issuer/name" or "ClusterIssuer/name".
+func parseIssuerRef(s string) (issuerRef, error) {
+	kind, name := "Issuer", s
+	if i := strings.Index(s, "/"); i >= 0 {
+		kind, name = s[:i], s[i+1:]
+	}
+	if kind != "Issuer" && kind != "ClusterIssuer" {
+		return issuerRef{}, fmt.Errorf("%q must be an Issuer or ClusterIssuer", kind)
+	}
+	if name == "" {
+		return issuerRef{}, fmt.Errorf("%q is not an issuer name, Issuer/name or ClusterIssuer/name", s)
+	}
+	return issuerRef{kind: kind, name: name}, nil
+}
+
+func parseTLSMode(s string) (string, error) {
+	switch s {
+	case tlsModeCertificate, tlsModeIngressShim:
+		return s, nil
+	}
+	return "", fmt.Errorf("%q must be one of %s or %s", s, tlsModeCertificate, tlsModeIngressShim)
+}
+
+// withCertManager enables the tls-issuer annotation, it is only called when cert-manager is installed.
+func (c *controller) withCertManager(client dynamic.Interface, certInformer informers.GenericInformer) {
+	c.dynamicClient = client
+	c.certificateLister = certInformer.Lister()
+	c.informersSynced = append(c.informersSynced, certInformer.Informer().HasSynced)
+	certInformer.Informer().AddEventHandler(c.ownedHandler())
+}
+
+func (c *controller) certManagerAvailable() bool {
+	return c.certificateLister != nil
+}
+
+// syncTLS generates the Certificate for an Ingress, or removes one we generated before, and reports whether the
+// certificate has been issued. The message is empty once it is Ready.
+func (c *controller) syncTLS(ctx context.Context, dep *appsv1.Deployment, cfg exposeConfig) (string, error) {
+	if cfg.tlsIssuer.name == "" || c.tlsMode != tlsModeCertificate {
+		if err := c.deleteOwnedCertificate(ctx, dep); err != nil {
+			return "", err
+		}
+	}
+	if cfg.tlsIssuer.name == "" {
+		return "", nil
+	}
+
+	// ingress-shim names the certificate after the secret, it isn't cached as it doesn't carry our label
+	name := cfg.tlsSecret
+	var cert *unstructured.Unstructured
+	if c.tlsMode == tlsModeCertificate {
+		name = dep.Name
+		desired := newCertificate(dep, cfg)
+		if err := c.syncUnstructured(ctx, dep, certificateGVR, c.certificateLister, desired); err != nil {
+			return "", err
+		}
+		obj, err := c.certificateLister.ByNamespace(dep.Namespace).Get(name)
+		if err == nil {
+			cert, _ = obj.(*unstructured.Unstructured)
+		}
+	} else {
+		var err error
+		cert, err = c.dynamicClient.Resource(certificateGVR).Namespace(dep.Namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("getting certificate: %w", err)
+		}
+	}
+
+	msg := certificateNotReady(name, cert)
+	// a certificate we just created isn't cached yet, its informer event syncs the deployment again
+	if msg != "" && (cert != nil || c.tlsMode == tlsModeIngressShim) {
+		c.recordWarning(ctx, dep, "CertificateNotReady", msg)
+	}
+	return msg, nil
+}
+
+// deleteOwnedCertificate removes the certificate generated for a deployment, the secret is left for cert-manager.
+func (c *controller) deleteOwnedCertificate(ctx context.Context, dep *appsv1.Deployment) error {
+	if !c.certManagerAvailable() {
+		return nil
+	}
+	return c.deleteOwnedUnstructured(ctx, dep, certificateGVR, c.certificateLister, "Certificate")
+}
+
+// newCertificate returns a Certificate for the host of the deployment, stored in the secret used by the Ingress.
+func newCertificate(dep *appsv1.Deployment, cfg exposeConfig) *unstructured.Unstructured {
+	cert := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"secretName": cfg.tlsSecret,
+			"dnsNames":   []any{cfg.host},
+			"issuerRef": map[string]any{
+				"group": certManagerGroup,
+				"kind":  cfg.tlsIssuer.kind,
+				"name":  cfg.tlsIssuer.name,
+			},
+		},
+	}}
+	cert.SetGroupVersionKind(certificateGVR.GroupVersion().WithKind("Certificate"))
+	meta := newObjectMeta(dep)
+	cert.SetName(meta.Name)
+	cert.SetNamespace(meta.Namespace)
+	cert.SetLabels(meta.Labels)
+	cert.SetOwnerReferences(meta.OwnerReferences)
+	return cert
+}
+
+// certificateNotReady describes why a certificate isn't Ready yet, it returns an empty string once it is.
+func certificateNotReady(name string, cert *unstructured.Unstructured) string {
+	if cert == nil {
+		return fmt.Sprintf("waiting for certificate %s to be created", name)
+	}
+	conditions, _, _ := unstructured.NestedSlice(cert.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]any)
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		if condition["status"] == "True" {
+			return ""
+		}
+		if msg, _ := condition["message"].(string); msg != "" {
+			return fmt.Sprintf("certificate %s is not ready: %s", cert.GetName(), msg)
+		}
+	}
+	return fmt.Sprintf("certificate %s is not ready, waiting for it to be issued", cert.GetName())
+}
+
+// withIngressShim annotates an Ingress so that cert-manager's ingress-shim issues its certificate.
+func withIngressShim(ing *networkingv1.Ingress, issuer issuerRef) {
+	key := issuerIngressAnnotation
+	if issuer.kind == "ClusterIssuer" {
+		key = clusterIssuerIngressAnnotation
+	}
+	if ing.Annotations == nil {
+		ing.Annotations = map[string]string{}
+	}
+	ing.Annotations[key] = issuer.name
+}
+
+// hasIngressShimAnnotations reports whether the ingress-shim annotations of an Ingress are exactly those wanted,
+// a leftover annotation would have ingress-shim issue a second certificate for the same secret.
+func hasIngressShimAnnotations(annotations, wanted map[string]string) bool {
+	for _, key := range ingressShimAnnotations {
+		if annotations[key] != wanted[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeIngressShimAnnotations sets the wanted ingress-shim annotations and removes the others, annotations added
+// by users are kept.
+func mergeIngressShimAnnotations(annotations, wanted map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	for _, key := range ingressShimAnnotations {
+		if v, ok := wanted[key]; ok {
+			merged[key] = v
+		} else {
+			delete(merged, key)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withCertManager enables the tls-issuer annotation on a fake dynamic client with the Certificate resource
+// registered, certificates are added to the informer indexer like the objects of newFixture.
+func (f *fixture) withCertManager(certificates ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	f.t.Helper()
+	var objects []runtime.Object
+	for _, cert := range certificates {
+		objects = append(objects, cert)
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		certificateGVR: "CertificateList",
+	}, objects...)
+	informer := dynamicinformer.NewDynamicSharedInformerFactory(client, 0).ForResource(certificateGVR)
+	for _, cert := range certificates {
+		if err := informer.Informer().GetIndexer().Add(cert); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	f.controller.withCertManager(client, informer)
+	return client
+}
+
+// dynamicActions returns the requests made through a fake dynamic client as "verb resource".
+func dynamicActions(client *dynamicfake.FakeDynamicClient) []string {
+	var actions []string
+	for _, a := range client.Actions() {
+		actions = append(actions, a.GetVerb()+" "+a.GetResource().Resource)
+	}
+	return actions
+}
+
+func expectDynamicActions(t *testing.T, client *dynamicfake.FakeDynamicClient, want ...string) {
+	t.Helper()
+	if got := dynamicActions(client); !reflect.DeepEqual(got, want) {
+		t.Errorf("dynamic actions = %q, want %q", got, want)
+	}
+	client.ClearActions()
+}
+
+// syncedStatusMessage returns the message of the status annotation written back to a deployment.
+func syncedStatusMessage(t *testing.T, f *fixture, name string) string {
+	t.Helper()
+	dep, err := f.clientset.AppsV1().Deployments(v1.NamespaceDefault).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status exposeStatus
+	if err := json.Unmarshal([]byte(dep.Annotations[statusAnnotation]), &status); err != nil {
+		t.Fatalf("status annotation: %v", err)
+	}
+	return status.Message
+}
+
+func tlsDeployment() *appsv1.Deployment {
+	return newDeployment("web", enabled(hostAnnotation, "web.example.com", tlsIssuerAnnotation, "ClusterIssuer/letsencrypt"))
+}
+
+func TestSyncCreatesCertificate(t *testing.T) {
+	dep := tlsDeployment()
+	f := newFixture(t, dep)
+	client := f.withCertManager()
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("create services", "create ingresses", "patch deployments")
+	expectDynamicActions(t, client, "create certificates")
+	f.expectEvents("ServiceCreated", "IngressCreated", "CertificateCreated")
+
+	cert, err := client.Resource(certificateGVR).Namespace(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v1.IsControlledBy(cert, dep) {
+		t.Errorf("certificate isn't controlled by the deployment: %v", cert.GetOwnerReferences())
+	}
+	issuer, _, _ := unstructured.NestedStringMap(cert.Object, "spec", "issuerRef")
+	if issuer["kind"] != "ClusterIssuer" || issuer["name"] != "letsencrypt" {
+		t.Errorf("issuerRef = %v, want ClusterIssuer letsencrypt", issuer)
+	}
+	secret, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+	if secret != "web-tls" {
+		t.Errorf("secretName = %q, want web-tls", secret)
+	}
+	if msg := syncedStatusMessage(t, f, dep.Name); !strings.Contains(msg, "waiting for certificate web") {
+		t.Errorf("status message = %q, want waiting for the certificate", msg)
+	}
+}
+
+func TestSyncCertificateNotReady(t *testing.T) {
+	dep := tlsDeployment()
+	cfg, err := parseExposeConfig(dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, ing := generated(t, dep)
+	cert := newCertificate(dep, cfg)
+	err = unstructured.SetNestedSlice(cert.Object, []any{
+		map[string]any{"type": "Ready", "status": "False", "message": "issuer letsencrypt not found"},
+	}, "status", "conditions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFixture(t, dep, svc, ing)
+	client := f.withCertManager(cert)
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("patch deployments")
+	expectDynamicActions(t, client)
+	f.expectEvents("CertificateNotReady")
+	if msg := syncedStatusMessage(t, f, dep.Name); !strings.Contains(msg, "issuer letsencrypt not found") {
+		t.Errorf("status message = %q, want the Ready condition message", msg)
+	}
+}
+
+func TestSyncIngressShim(t *testing.T) {
+	dep := tlsDeployment()
+	f := newFixture(t, dep)
+	client := f.withCertManager()
+	f.controller.tlsMode = tlsModeIngressShim
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	// ingress-shim creates the certificate, it is only looked up
+	f.expectActions("create services", "create ingresses", "patch deployments")
+	expectDynamicActions(t, client, "get certificates")
+	f.expectEvents("ServiceCreated", "IngressCreated", "CertificateNotReady")
+
+	ing, err := f.clientset.NetworkingV1().Ingresses(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ing.Annotations[clusterIssuerIngressAnnotation]; got != "letsencrypt" {
+		t.Errorf("%s = %q, want letsencrypt", clusterIssuerIngressAnnotation, got)
+	}
+	if _, ok := ing.Annotations[issuerIngressAnnotation]; ok {
+		t.Errorf("%s is set, want only the cluster issuer", issuerIngressAnnotation)
+	}
+	if tls := ing.Spec.TLS; len(tls) != 1 || tls[0].SecretName != "web-tls" {
+		t.Errorf("ingress tls = %v, want web-tls", tls)
+	}
+}
+
+func TestCertificateEventEnqueuesDeployment(t *testing.T) {
+	dep := tlsDeployment()
+	cfg, err := parseExposeConfig(dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFixture(t, dep)
+	f.withCertManager()
+
+	// e.g. the certificate was issued, the status of the deployment is updated
+	cert := newCertificate(dep, cfg)
+	f.controller.ownedHandler().OnUpdate(cert, cert)
+	if got := f.controller.queue.Len(); got != 1 {
+		t.Errorf("queue length = %d, want the deployment of the certificate queued", got)
+	}
+}
+
+func TestSyncRejectsUnownedCertificate(t *testing.T) {
+	dep := tlsDeployment()
+	svc, ing := generated(t, dep)
+	f := newFixture(t, dep, svc, ing)
+	client := f.withCertManager()
+	// a certificate of the same name created by someone else, without our label it isn't cached
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(certificateGVR.GroupVersion().WithKind("Certificate"))
+	cert.SetNamespace(dep.Namespace)
+	cert.SetName(dep.Name)
+	if err := client.Tracker().Add(cert); err != nil {
+		t.Fatal(err)
+	}
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("patch deployments")
+	expectDynamicActions(t, client, "create certificates", "get certificates")
+	f.expectEvents("ReconcileFailed")
+	if msg := syncedStatusMessage(t, f, dep.Name); !strings.Contains(msg, "not managed by expose") {
+		t.Errorf("status message = %q, want the certificate reported as not managed by expose", msg)
+	}
+}
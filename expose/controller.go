@@ -3,88 +3,387 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/informers/apps/v1beta2"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	networkinginformers "k8s.io/client-go/informers/networking/v1"
 	"k8s.io/client-go/kubernetes"
-	v1beta22 "k8s.io/client-go/listers/apps/v1beta2"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"os"
+	"sync/atomic"
+	"time"
 )
 
+// maxRetries is the number of times a deployment is retried before it is dropped out of the queue.
+const maxRetries = 5
+
 type controller struct {
-	clientset        kubernetes.Clientset
-	deploymentLister v1beta22.DeploymentLister
-	informerSynced   cache.InformerSynced
+	clientset kubernetes.Interface
+	// namespace is watched by the controller, v1.NamespaceAll for every namespace
+	namespace string
+	// fieldSelector excludes namespaces when watching every namespace, see scope
+	fieldSelector    string
+	deploymentLister appslisters.DeploymentLister
+	serviceLister    corelisters.ServiceLister
+	ingressLister    networkinglisters.IngressLister
+	informersSynced  []cache.InformerSynced
 	queue            workqueue.RateLimitingInterface
+	recorder         record.EventRecorder
+
+	// defaultOutput and defaultGateway apply to deployments without the output or gateway annotations
+	defaultOutput  string
+	defaultGateway parentGateway
+	// dynamicClient and routeLister are only set when the Gateway API is installed, see withGatewayAPI
+	dynamicClient dynamic.Interface
+	routeLister   cache.GenericLister
+	// certificateLister is only set when cert-manager is installed, see withCertManager
+	certificateLister cache.GenericLister
+	// tlsMode is how certificates are requested for the tls-issuer annotation, see certmanager.go
+	tlsMode string
+
+	// dryRun prints planned changes to planOut in planFormat, rather than or as well as applying them, see plan
+	dryRun     string
+	planFormat string
+	planOut    io.Writer
+	// synced is set once the informer caches have synced, used for readiness
+	synced atomic.Bool
+	// shutdownTimeout bounds how long run waits for in-flight reconciles once stopped
+	shutdownTimeout time.Duration
+	// clock is shared with the queue, see newControllerWithClock
+	clock clock.PassiveClock
+}
+
+// newController watches deployments, along with the services and ingresses generated for them so that
+// manual edits to those are corrected.
+func newController(
+	clientset kubernetes.Interface,
+	namespace string,
+	depInformer appsinformers.DeploymentInformer,
+	svcInformer coreinformers.ServiceInformer,
+	ingInformer networkinginformers.IngressInformer,
+) *controller {
+	return newControllerWithClock(clock.RealClock{}, clientset, namespace, depInformer, svcInformer, ingInformer)
 }
 
-func newController(clientset kubernetes.Clientset, depInformer v1beta2.DeploymentInformer) *controller {
+// newControllerWithClock is newController with the clock used for retry backoff and status timestamps, a fake
+// clock lets a test step through retries without waiting for them.
+func newControllerWithClock(
+	clk clock.WithTicker,
+	clientset kubernetes.Interface,
+	namespace string,
+	depInformer appsinformers.DeploymentInformer,
+	svcInformer coreinformers.ServiceInformer,
+	ingInformer networkinginformers.IngressInformer,
+) *controller {
+	// events are recorded against deployments, e.g. to report a malformed annotation
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+
 	c := &controller{
 		clientset:        clientset,
+		namespace:        namespace,
 		deploymentLister: depInformer.Lister(),
-		informerSynced:   depInformer.Informer().HasSynced,
-		queue:            workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		serviceLister:    svcInformer.Lister(),
+		ingressLister:    ingInformer.Lister(),
+		informersSynced: []cache.InformerSynced{
+			depInformer.Informer().HasSynced,
+			svcInformer.Informer().HasSynced,
+			ingInformer.Informer().HasSynced,
+		},
+		queue: workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{
+			Name:  queueName(namespace),
+			Clock: clk,
+		}),
+		clock:           clk,
+		recorder:        broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "expose"}),
+		defaultOutput:   outputIngress,
+		tlsMode:         tlsModeCertificate,
+		dryRun:          dryRunNone,
+		planFormat:      planFormatYAML,
+		planOut:         os.Stdout,
+		shutdownTimeout: 30 * time.Second,
 	}
 
 	depInformer.Informer().AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc:    c.handleAdd,
+			UpdateFunc: c.handleUpdate,
 			DeleteFunc: c.handleDel,
 		})
 
+	svcInformer.Informer().AddEventHandler(c.ownedHandler())
+	ingInformer.Informer().AddEventHandler(c.ownedHandler())
+
 	return c
 }
 
-func (c *controller) run(ch <-chan struct{}) {
-	fmt.Println("starting controller")
-	if !cache.WaitForCacheSync(ch, c.informerSynced) {
-		// wait for the informer to fill cache before starting
-		fmt.Println("waiting for cache to sync")
+// errDrainTimeout is returned by run when in-flight reconciles didn't finish within the shutdown timeout.
+var errDrainTimeout = fmt.Errorf("timed out waiting for in-flight reconciles to finish")
+
+// run starts the workers and blocks until ctx is cancelled, then lets in-flight reconciles finish within
+// shutdownTimeout. Keys waiting out a retry backoff are dropped, they are synced again on the next start.
+func (c *controller) run(ctx context.Context) error {
+	logger := klog.LoggerWithValues(klog.FromContext(ctx), "controller", queueName(c.namespace))
+	logger.Info("Starting controller")
+	defer c.queue.ShutDown()
+
+	// wait for the informer to fill cache before starting
+	if !c.waitForCacheSync(ctx.Done()) {
+		return fmt.Errorf("timed out waiting for cache to sync")
+	}
+
+	if err := c.deleteOrphans(ctx); err != nil {
+		logger.Error(err, "Failed to delete orphaned resources")
+	}
+
+	// workers return once the queue is shut down, they aren't given ctx so that in-flight reconciles can finish
+	go wait.Until(func() { c.worker(logger) }, time.Second, ctx.Done())
+
+	<-ctx.Done()
+	logger.Info("Stopping controller, waiting for in-flight reconciles", "timeout", c.shutdownTimeout)
+	drained := make(chan struct{})
+	go func() {
+		c.queue.ShutDownWithDrain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(c.shutdownTimeout):
+		return errDrainTimeout
 	}
 }
 
-func (c *controller) worker() {
-	for c.processItem() {
+// waitForCacheSync blocks until the informer caches have synced, or ch is closed.
+func (c *controller) waitForCacheSync(ch <-chan struct{}) bool {
+	if !cache.WaitForCacheSync(ch, c.informersSynced...) {
+		return false
+	}
+	c.synced.Store(true)
+	return true
+}
+
+func (c *controller) hasSynced() bool {
+	return c.synced.Load()
+}
+
+func (c *controller) worker(logger klog.Logger) {
+	for c.processItem(logger) {
 
 	}
 }
 
-func (c *controller) processItem() bool {
-	// get item by key from queue
-	item, shutdown := c.queue.Get()
+func (c *controller) processItem(logger klog.Logger) bool {
+	// get key from queue
+	key, shutdown := c.queue.Get()
 	if shutdown {
 		return false
 	}
+	// finished processing the key, it can be handed out again
+	defer c.queue.Done(key)
 
-	defer c.queue.Forget(item)
-	// return item from cache by key
-	key, err := cache.MetaNamespaceKeyFunc(item)
-	if err != nil {
-		fmt.Printf("failed to get item from cache%s\n", err)
+	// everything logged during a reconcile carries the deployment, an ID to group its lines and the attempt
+	logger = klog.LoggerWithValues(logger,
+		"deployment", key,
+		"reconcileID", uuid.NewUUID(),
+		"attempt", c.queue.NumRequeues(key)+1,
+	)
+	ctx := klog.NewContext(context.Background(), logger)
+
+	err := c.syncDeployment(ctx, key.(string))
+	c.handleError(ctx, err, key)
+	return true
+}
+
+// handleError retries a failed key with backoff, a key which keeps failing is dropped after maxRetries.
+// Retry logic lives here rather than in the business logic.
+func (c *controller) handleError(ctx context.Context, err error, key any) {
+	if err == nil {
+		c.queue.Forget(key)
+		return
 	}
 
+	logger := klog.FromContext(ctx)
+	if c.queue.NumRequeues(key) < maxRetries {
+		logger.Error(err, "Failed to sync deployment, retrying")
+		c.queue.AddRateLimited(key)
+		return
+	}
+
+	c.queue.Forget(key)
+	logger.Error(err, "Dropping deployment out of the queue", "maxRetries", maxRetries)
+}
+
+// syncDeployment looks up the deployment for a namespace/name key and reconciles it.
+func (c *controller) syncDeployment(ctx context.Context, key string) error {
 	ns, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
-		fmt.Printf("sputting key into namespace and name failed %s\n", err)
-		return false
+		// an invalid key will never succeed, so don't retry it
+		runtime.HandleError(fmt.Errorf("splitting key into namespace and name failed %w", err))
+		return nil
 	}
 
-	ctx := context.Background()
-	_, err = c.clientset.AppsV1().Deployments(ns).Get(ctx, name, v1.GetOptions{})
+	dep, err := c.deploymentLister.Deployments(ns).Get(name)
 	if errors.IsNotFound(err) {
-		fmt.Println("not implemented!")
+		// the deployment has gone, the garbage collector removes what we created via owner references
+		klog.FromContext(ctx).V(2).Info("Deployment no longer exists")
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	return false
+	start := time.Now()
+	err = c.sync(ctx, dep)
+	observeReconcile(start, err)
+	if err != nil {
+		c.recordWarning(ctx, dep, "ReconcileFailed", err.Error())
+	}
+	klog.FromContext(ctx).V(2).Info("Reconciled deployment", "duration", time.Since(start), "failed", err != nil)
+	return err
+}
+
+// recordAnnotationError reports a malformed annotation as a Warning event on the deployment. A user has to
+// fix the annotation, so there is no point in retrying.
+func (c *controller) recordAnnotationError(ctx context.Context, dep *appsv1.Deployment, err error) {
+	reason := "InvalidAnnotation"
+	if aErr, ok := err.(*annotationError); ok {
+		reason = aErr.reason
+	}
+	c.recordWarning(ctx, dep, reason, err.Error())
+}
+
+func (c *controller) recordWarning(ctx context.Context, dep *appsv1.Deployment, reason, msg string) {
+	c.recordEvent(ctx, dep, corev1.EventTypeWarning, reason, msg)
+}
+
+// recordNormal reports a change made for the deployment, e.g. ServiceCreated.
+func (c *controller) recordNormal(ctx context.Context, dep *appsv1.Deployment, reason, msg string) {
+	c.recordEvent(ctx, dep, corev1.EventTypeNormal, reason, msg)
+}
+
+func (c *controller) recordEvent(ctx context.Context, dep *appsv1.Deployment, eventType, reason, msg string) {
+	klog.FromContext(ctx).Info("Recording event", "type", eventType, "reason", reason, "message", msg)
+	// events are writes too, a dry-run only prints them
+	if c.dryRun != dryRunNone {
+		return
+	}
+	c.recorder.Event(dep, eventType, reason, msg)
+}
+
+// enqueue adds the namespace/name key of a deployment to the queue, event is the reason it is logged with.
+func (c *controller) enqueue(obj any, event string) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	klog.V(4).InfoS("Enqueueing deployment", "deployment", key, "event", event)
+	c.queue.Add(key)
 }
 
 func (c *controller) handleAdd(obj any) {
-	fmt.Println("add was called")
-	c.queue.Add(obj)
+	// only deployments with the enabled annotation are of interest, the value itself is checked during sync
+	// so that a malformed value is reported
+	if !hasEnabledAnnotation(obj) {
+		return
+	}
+	c.enqueue(obj, "add")
+}
+
+func (c *controller) handleUpdate(old, new any) {
+	// the old deployment is checked too, removing the annotation means the generated resources are deleted
+	if !hasEnabledAnnotation(old) && !hasEnabledAnnotation(new) {
+		return
+	}
+	// writing the status annotation is an update too, it would otherwise sync the deployment again
+	if oldDep, ok := old.(*appsv1.Deployment); ok {
+		if newDep, ok := new.(*appsv1.Deployment); ok && onlyStatusChanged(oldDep, newDep) {
+			return
+		}
+	}
+	c.enqueue(new, "update")
 }
 
 func (c *controller) handleDel(obj any) {
-	fmt.Println("del was called")
-	c.queue.Add(obj)
+	// when the watch missed the delete the informer hands us a tombstone, holding the last known state
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		if !hasEnabledAnnotation(tombstone.Obj) {
+			return
+		}
+		klog.V(4).InfoS("Enqueueing deployment", "deployment", tombstone.Key, "event", "delete")
+		c.queue.Add(tombstone.Key)
+		return
+	}
+
+	if !hasEnabledAnnotation(obj) {
+		return
+	}
+	c.enqueue(obj, "delete")
+}
+
+// ownedHandler handles events of the resources generated for deployments, those of the optional Gateway API and
+// cert-manager informers too.
+func (c *controller) ownedHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handleOwned,
+		UpdateFunc: func(old, new any) { c.handleOwned(new) },
+		DeleteFunc: c.handleOwned,
+	}
+}
+
+// handleOwned enqueues the deployment which owns a generated service or ingress, so that drift is corrected.
+func (c *controller) handleOwned(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+
+	ref := v1.GetControllerOf(object)
+	if ref == nil || ref.Kind != "Deployment" {
+		return
+	}
+	dep, err := c.deploymentLister.Deployments(object.GetNamespace()).Get(ref.Name)
+	if err != nil || dep.UID != ref.UID {
+		return
+	}
+	c.enqueue(dep, "owned resource changed")
+}
+
+// queueName names the queue in metrics, a controller is run for each watched namespace.
+func queueName(namespace string) string {
+	if namespace == v1.NamespaceAll {
+		return "expose"
+	}
+	return "expose/" + namespace
+}
+
+func hasEnabledAnnotation(obj any) bool {
+	dep, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return false
+	}
+	_, ok = dep.Annotations[enabledAnnotation]
+	return ok
 }
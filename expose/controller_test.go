@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2/ktesting"
+	testingclock "k8s.io/utils/clock/testing"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixture is a controller on a fake clientset. The informers aren't started, objects are added to their indexers
+// directly and keys are reconciled one at a time through processItem, so a test decides every step.
+type fixture struct {
+	t          *testing.T
+	clientset  *fake.Clientset
+	factory    informers.SharedInformerFactory
+	clock      *testingclock.FakeClock
+	recorder   *record.FakeRecorder
+	controller *controller
+}
+
+func newFixture(t *testing.T, objects ...runtime.Object) *fixture {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	clk := testingclock.NewFakeClock(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC))
+	c := newControllerWithClock(clk, clientset, v1.NamespaceAll,
+		factory.Apps().V1().Deployments(),
+		factory.Core().V1().Services(),
+		factory.Networking().V1().Ingresses(),
+	)
+	recorder := record.NewFakeRecorder(100)
+	c.recorder = recorder
+	c.planOut = io.Discard
+	t.Cleanup(c.queue.ShutDown)
+
+	f := &fixture{
+		t:          t,
+		clientset:  clientset,
+		factory:    factory,
+		clock:      clk,
+		recorder:   recorder,
+		controller: c,
+	}
+	for _, obj := range objects {
+		f.addToCache(obj)
+	}
+	return f
+}
+
+// addToCache adds an object to the indexer of its informer, as if it had been listed.
+func (f *fixture) addToCache(obj runtime.Object) {
+	f.t.Helper()
+	var indexer cache.Indexer
+	switch obj.(type) {
+	case *appsv1.Deployment:
+		indexer = f.factory.Apps().V1().Deployments().Informer().GetIndexer()
+	case *corev1.Service:
+		indexer = f.factory.Core().V1().Services().Informer().GetIndexer()
+	case *networkingv1.Ingress:
+		indexer = f.factory.Networking().V1().Ingresses().Informer().GetIndexer()
+	default:
+		f.t.Fatalf("no informer for %T", obj)
+	}
+	if err := indexer.Add(obj); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// addUncached adds an object to the clientset only, like one without the managed-by label which the informers
+// filter out.
+func (f *fixture) addUncached(obj runtime.Object) {
+	f.t.Helper()
+	if err := f.clientset.Tracker().Add(obj); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// processItem reconciles the next key of the queue, it fails the test rather than block when none is queued.
+func (f *fixture) processItem() {
+	f.t.Helper()
+	if f.controller.queue.Len() == 0 {
+		f.t.Fatal("no key queued")
+	}
+	logger, _ := ktesting.NewTestContext(f.t)
+	f.controller.processItem(logger)
+}
+
+// waitForQueued waits for a key waiting out its retry backoff to be added back to the queue.
+func (f *fixture) waitForQueued() {
+	f.t.Helper()
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return f.controller.queue.Len() > 0, nil
+	})
+	if err != nil {
+		f.t.Fatal("key wasn't queued again")
+	}
+}
+
+// actions returns the writes made through the clientset as "verb resource", e.g. "create services".
+func (f *fixture) actions() []string {
+	var actions []string
+	for _, a := range f.clientset.Actions() {
+		switch a.GetVerb() {
+		case "get", "list", "watch":
+			continue
+		}
+		actions = append(actions, a.GetVerb()+" "+a.GetResource().Resource)
+	}
+	return actions
+}
+
+func (f *fixture) expectActions(want ...string) {
+	f.t.Helper()
+	if got := f.actions(); !reflect.DeepEqual(got, want) {
+		f.t.Errorf("actions = %q, want %q", got, want)
+	}
+	f.clientset.ClearActions()
+}
+
+// events drains the recorded events, e.g. "Normal ServiceCreated created service web".
+func (f *fixture) events() []string {
+	var events []string
+	for {
+		select {
+		case e := <-f.recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+// expectEvents checks the reason of each recorded event.
+func (f *fixture) expectEvents(reasons ...string) {
+	f.t.Helper()
+	var got []string
+	for _, e := range f.events() {
+		if fields := strings.Fields(e); len(fields) > 1 {
+			got = append(got, fields[1])
+		}
+	}
+	if !reflect.DeepEqual(got, reasons) {
+		f.t.Errorf("events = %q, want %q", got, reasons)
+	}
+}
+
+// newDeployment returns a deployment in the default namespace with a single named container port. The pod template
+// carries a version label on top of the selector labels.
+func newDeployment(name string, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        name,
+			Namespace:   v1.NamespaceDefault,
+			UID:         types.UID(name + "-uid"),
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &v1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"app": name, "version": "v1"}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  name,
+						Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func enabled(annotations ...string) map[string]string {
+	a := map[string]string{enabledAnnotation: "true"}
+	for i := 0; i+1 < len(annotations); i += 2 {
+		a[annotations[i]] = annotations[i+1]
+	}
+	return a
+}
+
+// generated returns the service and ingress the controller generates for a deployment.
+func generated(t *testing.T, dep *appsv1.Deployment) (*corev1.Service, *networkingv1.Ingress) {
+	t.Helper()
+	cfg, err := parseExposeConfig(dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := newService(dep, cfg)
+	return svc, newIngress(dep, svc, cfg)
+}
+
+func TestSyncCreatesServiceAndIngress(t *testing.T) {
+	dep := newDeployment("web", enabled(hostAnnotation, "web.example.com"))
+	f := newFixture(t, dep)
+
+	f.controller.handleAdd(dep)
+	f.processItem()
+
+	f.expectActions("create services", "create ingresses", "patch deployments")
+	f.expectEvents("ServiceCreated", "IngressCreated")
+
+	svc, err := f.clientset.CoreV1().Services(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v1.IsControlledBy(svc, dep) {
+		t.Errorf("service isn't controlled by the deployment: %v", svc.OwnerReferences)
+	}
+	if got := svc.Spec.Ports; len(got) != 1 || got[0].Port != 8080 {
+		t.Errorf("service ports = %v, want 8080", got)
+	}
+
+	ing, err := f.clientset.NetworkingV1().Ingresses(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := ing.Spec.Rules[0]
+	if rule.Host != "web.example.com" || rule.HTTP.Paths[0].Backend.Service.Name != svc.Name {
+		t.Errorf("ingress rule = %+v, want web.example.com to service %s", rule, svc.Name)
+	}
+}
+
+func TestSyncUpdatesDriftedResources(t *testing.T) {
+	dep := newDeployment("web", enabled(hostAnnotation, "web.example.com"))
+	svc, ing := generated(t, dep)
+	svc.Spec.Selector = map[string]string{"app": "other"}
+	ing.Spec.Rules[0].Host = "other.example.com"
+	f := newFixture(t, dep, svc, ing)
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("update services", "update ingresses", "patch deployments")
+	f.expectEvents("ServiceUpdated", "IngressUpdated")
+
+	updated, err := f.clientset.CoreV1().Services(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := updated.Spec.Selector["app"]; got != "web" {
+		t.Errorf("selector app = %q, want web", got)
+	}
+}
+
+func TestSyncLeavesResourcesInSync(t *testing.T) {
+	dep := newDeployment("web", enabled())
+	svc, ing := generated(t, dep)
+	f := newFixture(t, dep, svc, ing)
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	// only the status annotation is written
+	f.expectActions("patch deployments")
+	f.expectEvents()
+}
+
+func TestSyncDeletesWhenDisabled(t *testing.T) {
+	old := newDeployment("web", enabled())
+	dep := newDeployment("web", map[string]string{enabledAnnotation: "false"})
+	svc, ing := generated(t, old)
+	f := newFixture(t, dep, svc, ing)
+
+	f.controller.handleUpdate(old, dep)
+	f.processItem()
+
+	f.expectActions("delete ingresses", "delete services")
+	f.expectEvents("IngressDeleted", "ServiceDeleted")
+}
+
+func TestSyncRetriesWithBackoff(t *testing.T) {
+	dep := newDeployment("web", enabled())
+	f := newFixture(t, dep)
+	failures := 1
+	f.clientset.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, errors.New("api server unavailable")
+	})
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("create services", "patch deployments")
+	f.expectEvents("ReconcileFailed")
+	key := "default/web"
+	if got := f.controller.queue.NumRequeues(key); got != 1 {
+		t.Fatalf("requeues = %d, want 1", got)
+	}
+	if got := f.controller.queue.Len(); got != 0 {
+		t.Fatalf("queue length = %d, want the key to wait out its backoff", got)
+	}
+
+	f.clock.Step(time.Second)
+	f.waitForQueued()
+	f.processItem()
+
+	f.expectActions("create services", "create ingresses", "patch deployments")
+	f.expectEvents("ServiceCreated", "IngressCreated")
+	if got := f.controller.queue.NumRequeues(key); got != 0 {
+		t.Errorf("requeues = %d, want the key forgotten after a successful sync", got)
+	}
+}
+
+func TestHandleDelTombstone(t *testing.T) {
+	dep := newDeployment("web", enabled())
+	f := newFixture(t)
+
+	f.controller.handleDel(cache.DeletedFinalStateUnknown{Key: "default/web", Obj: dep})
+	if got := f.controller.queue.Len(); got != 1 {
+		t.Fatalf("queue length = %d, want the tombstone key queued", got)
+	}
+	f.processItem()
+
+	// the deployment has gone, its service and ingress are removed by the garbage collector
+	f.expectActions()
+
+	f.controller.handleDel(cache.DeletedFinalStateUnknown{Key: "default/off", Obj: newDeployment("off", nil)})
+	if got := f.controller.queue.Len(); got != 0 {
+		t.Errorf("queue length = %d, want a tombstone without the enabled annotation ignored", got)
+	}
+}
+
+func TestHandleOwnedTombstone(t *testing.T) {
+	dep := newDeployment("web", enabled())
+	svc, ing := generated(t, dep)
+	f := newFixture(t, dep, ing)
+
+	// the service was deleted while the watch was down, it is created again
+	f.controller.handleOwned(cache.DeletedFinalStateUnknown{Key: "default/web", Obj: svc})
+	f.processItem()
+
+	f.expectActions("create services", "patch deployments")
+	f.expectEvents("ServiceCreated")
+}
+
+func TestSyncLeavesUnownedResources(t *testing.T) {
+	// a service and an ingress of the same name, created by someone else
+	svc := &corev1.Service{ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: v1.NamespaceDefault}}
+	ing := &networkingv1.Ingress{ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: v1.NamespaceDefault}}
+
+	t.Run("disabled", func(t *testing.T) {
+		dep := newDeployment("web", map[string]string{enabledAnnotation: "false"})
+		f := newFixture(t, dep, svc, ing)
+
+		f.controller.enqueue(dep, "test")
+		f.processItem()
+
+		f.expectActions()
+		f.expectEvents()
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		dep := newDeployment("web", enabled())
+		f := newFixture(t, dep, svc, ing)
+
+		f.controller.enqueue(dep, "test")
+		f.processItem()
+
+		// the failure is recorded, the service is neither updated nor deleted
+		f.expectActions("patch deployments")
+		f.expectEvents("ReconcileFailed")
+	})
+
+	// the informers in main only cache objects with the managed-by label, those of someone else are only found
+	// through the api server
+	t.Run("uncached service", func(t *testing.T) {
+		dep := newDeployment("web", enabled())
+		f := newFixture(t, dep)
+		f.addUncached(svc)
+
+		f.controller.enqueue(dep, "test")
+		f.processItem()
+
+		// no ingress is created for a service which isn't ours
+		f.expectActions("create services", "patch deployments")
+		f.expectEvents("ReconcileFailed")
+	})
+
+	t.Run("uncached ingress", func(t *testing.T) {
+		dep := newDeployment("web", enabled())
+		f := newFixture(t, dep)
+		f.addUncached(ing)
+
+		f.controller.enqueue(dep, "test")
+		f.processItem()
+
+		f.expectActions("create services", "create ingresses", "patch deployments")
+		f.expectEvents("ServiceCreated", "ReconcileFailed")
+		existing, err := f.clientset.NetworkingV1().Ingresses(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(existing.Spec.Rules) > 0 || len(existing.OwnerReferences) > 0 {
+			t.Errorf("ingress of someone else was changed: %+v", existing)
+		}
+	})
+}
+
+func TestSyncRestoresRemovedManagedByLabel(t *testing.T) {
+	dep := newDeployment("web", enabled())
+	svc, ing := generated(t, dep)
+	delete(svc.Labels, managedByLabel)
+	f := newFixture(t, dep, ing)
+	// without the label the service drops out of the cache
+	f.addUncached(svc)
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("create services", "update services", "patch deployments")
+	f.expectEvents("ServiceUpdated")
+	updated, err := f.clientset.CoreV1().Services(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := updated.Labels[managedByLabel]; got != managedByValue {
+		t.Errorf("%s = %q, want %s", managedByLabel, got, managedByValue)
+	}
+}
+
+func TestSyncIgnoresTemplateLabelChange(t *testing.T) {
+	dep := newDeployment("web", enabled())
+	svc, ing := generated(t, dep)
+	// a rollout of a new version, the old pods keep serving until the new ones are ready
+	dep.Spec.Template.Labels["version"] = "v2"
+	f := newFixture(t, dep, svc, ing)
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("patch deployments")
+	f.expectEvents()
+	if _, ok := svc.Spec.Selector["version"]; ok {
+		t.Errorf("selector = %v, want only the deployment selector labels", svc.Spec.Selector)
+	}
+	if _, ok := svc.Labels["version"]; ok {
+		t.Errorf("labels = %v, want only the deployment selector labels and %s", svc.Labels, managedByLabel)
+	}
+}
+
+func TestSyncRejectsSelectorWithoutMatchLabels(t *testing.T) {
+	dep := newDeployment("web", enabled())
+	dep.Spec.Selector = &v1.LabelSelector{MatchExpressions: []v1.LabelSelectorRequirement{
+		{Key: "app", Operator: v1.LabelSelectorOpIn, Values: []string{"web"}},
+	}}
+	f := newFixture(t, dep)
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("patch deployments")
+	f.expectEvents("SelectorUnsupported")
+}
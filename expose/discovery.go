@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// requiredResources are the resources the controller watches or writes, each must be served by the cluster.
+var requiredResources = []schema.GroupVersionResource{
+	appsv1.SchemeGroupVersion.WithResource("deployments"),
+	networkingv1.SchemeGroupVersion.WithResource("ingresses"),
+}
+
+// checkServedResources uses discovery to fail early when the cluster does not serve a resource we depend on,
+// otherwise the informers would retry their list calls forever and the cache would never sync.
+func checkServedResources(client discovery.DiscoveryInterface) error {
+	for _, gvr := range requiredResources {
+		if err := checkServedResource(client, gvr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkServedResource(client discovery.DiscoveryInterface, gvr schema.GroupVersionResource) error {
+	gv := gvr.GroupVersion().String()
+	resources, err := client.ServerResourcesForGroupVersion(gv)
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("the API group version %s is not served by this cluster", gv)
+	}
+	if err != nil {
+		return fmt.Errorf("discovering resources for %s: %w", gv, err)
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource {
+			return nil
+		}
+	}
+	return fmt.Errorf("the resource %s is not served by %s on this cluster", gvr.Resource, gv)
+}
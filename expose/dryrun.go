@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Dry-run modes, selected with the --dry-run flag.
+const (
+	dryRunNone   = "none"
+	dryRunClient = "client"
+	dryRunServer = "server"
+)
+
+// Formats used to print planned changes in dry-run mode.
+const (
+	planFormatYAML = "yaml"
+	planFormatDiff = "diff"
+)
+
+func parseDryRun(s string) (string, error) {
+	switch s {
+	case dryRunNone, dryRunClient, dryRunServer:
+		return s, nil
+	}
+	return "", fmt.Errorf("%q must be one of %s, %s or %s", s, dryRunNone, dryRunClient, dryRunServer)
+}
+
+func parsePlanFormat(s string) (string, error) {
+	switch s {
+	case planFormatYAML, planFormatDiff:
+		return s, nil
+	}
+	return "", fmt.Errorf("%q must be one of %s or %s", s, planFormatYAML, planFormatDiff)
+}
+
+// plan reconciles every deployment in the informer cache once, printing the changes which would be made rather
+// than applying them. Server-side dry-run sends the changes with DryRun: All, so they are validated by admission.
+func (c *controller) plan(ctx context.Context) error {
+	deployments, err := c.deploymentLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("listing deployments: %w", err)
+	}
+
+	var failed int
+	for _, dep := range deployments {
+		ctx := klog.NewContext(ctx, klog.LoggerWithValues(klog.FromContext(ctx), "deployment", klog.KObj(dep)))
+		if err := c.sync(ctx, dep); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to plan deployment")
+			failed++
+		}
+	}
+	if err := c.deleteOrphans(ctx); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d deployments failed", failed, len(deployments))
+	}
+	return nil
+}
+
+// write applies a change to the cluster, verb is one of create, update or delete. In dry-run mode the change is
+// printed, and only sent to the api server for server-side dry-run. existing is nil for a create and desired is
+// nil for a delete.
+func (c *controller) write(verb string, existing, desired runtime.Object, apply func() error) error {
+	if c.dryRun == dryRunNone {
+		return apply()
+	}
+
+	if c.dryRun == dryRunServer {
+		if err := apply(); err != nil {
+			return err
+		}
+	}
+	return c.printPlan(verb, existing, desired)
+}
+
+func (c *controller) createOptions() v1.CreateOptions {
+	return v1.CreateOptions{DryRun: c.dryRunOption()}
+}
+
+func (c *controller) updateOptions() v1.UpdateOptions {
+	return v1.UpdateOptions{DryRun: c.dryRunOption()}
+}
+
+func (c *controller) deleteOptions() v1.DeleteOptions {
+	return v1.DeleteOptions{DryRun: c.dryRunOption()}
+}
+
+func (c *controller) dryRunOption() []string {
+	if c.dryRun == dryRunServer {
+		return []string{v1.DryRunAll}
+	}
+	return nil
+}
+
+// printPlan prints a planned change as the YAML of the resulting object, or as a diff against the existing object.
+func (c *controller) printPlan(verb string, existing, desired runtime.Object) error {
+	obj := desired
+	if obj == nil {
+		obj = existing
+	}
+	kind, name := describe(obj)
+	fmt.Fprintf(c.planOut, "# %s %s %s\n", verb, kind, name)
+
+	after := ""
+	if desired != nil {
+		var err error
+		after, err = toYAML(desired)
+		if err != nil {
+			return err
+		}
+	}
+	if c.planFormat == planFormatYAML {
+		if desired == nil {
+			return nil
+		}
+		fmt.Fprintf(c.planOut, "%s---\n", after)
+		return nil
+	}
+
+	before := ""
+	if existing != nil {
+		var err error
+		before, err = toYAML(existing)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(c.planOut, lineDiff(before, after))
+	return nil
+}
+
+// lineDiff returns a line by line diff of two documents, removed lines are prefixed with "-" and added
+// lines with "+". The objects we print are small, so the longest common subsequence is computed directly.
+func lineDiff(before, after string) string {
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// toYAML prints an object along with its apiVersion and kind, which typed objects from a lister don't carry.
+func toYAML(obj runtime.Object) (string, error) {
+	obj = obj.DeepCopyObject()
+	if accessor, ok := obj.(v1.Object); ok {
+		accessor.SetManagedFields(nil)
+	}
+	if gvks, _, err := scheme.Scheme.ObjectKinds(obj); err == nil && len(gvks) > 0 {
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+	}
+
+	b, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("printing %T as yaml: %w", obj, err)
+	}
+	return string(b), nil
+}
+
+// describe returns the kind and namespace/name of an object for the plan header.
+func describe(obj runtime.Object) (string, string) {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if gvks, _, err := scheme.Scheme.ObjectKinds(obj); kind == "" && err == nil && len(gvks) > 0 {
+		kind = gvks[0].Kind
+	}
+	if accessor, ok := obj.(v1.Object); ok {
+		return kind, accessor.GetNamespace() + "/" + accessor.GetName()
+	}
+	return kind, ""
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"strings"
+)
+
+// Output modes, selected with the --output flag or the output annotation.
+const (
+	outputIngress   = "ingress"
+	outputHTTPRoute = "httproute"
+)
+
+// httpRouteGVR is the HTTPRoute resource of the Gateway API, synced through syncUnstructured.
+var httpRouteGVR = schema.GroupVersionResource{
+	Group:    "gateway.networking.k8s.io",
+	Version:  "v1",
+	Resource: "httproutes",
+}
+
+// parentGateway references the Gateway an HTTPRoute attaches to.
+type parentGateway struct {
+	namespace string
+	name      string
+}
+
+// parseParentGateway parses a gateway given as "name" or "namespace/name", a missing namespace means the
+// namespace of the route.
+func parseParentGateway(s string) (parentGateway, error) {
+	ns, name, err := cache.SplitMetaNamespaceKey(s)
+	if err != nil || name == "" {
+		return parentGateway{}, fmt.Errorf("%q is not a gateway name or namespace/name", s)
+	}
+	return parentGateway{namespace: ns, name: name}, nil
+}
+
+func (g parentGateway) String() string {
+	if g.namespace == "" {
+		return g.name
+	}
+	return g.namespace + "/" + g.name
+}
+
+// withGatewayAPI enables the httproute output mode, it is only called when the Gateway API is installed.
+func (c *controller) withGatewayAPI(client dynamic.Interface, routeInformer informers.GenericInformer) {
+	c.dynamicClient = client
+	c.routeLister = routeInformer.Lister()
+	c.informersSynced = append(c.informersSynced, routeInformer.Informer().HasSynced)
+	routeInformer.Informer().AddEventHandler(c.ownedHandler())
+}
+
+func (c *controller) gatewayAPIAvailable() bool {
+	return c.routeLister != nil
+}
+
+// syncHTTPRoute creates the route, or updates it when it has drifted from the desired state.
+func (c *controller) syncHTTPRoute(ctx context.Context, dep *appsv1.Deployment, desired *unstructured.Unstructured) error {
+	return c.syncUnstructured(ctx, dep, httpRouteGVR, c.routeLister, desired)
+}
+
+// deleteOwnedHTTPRoute removes the route generated for a deployment, e.g. after switching back to an Ingress.
+func (c *controller) deleteOwnedHTTPRoute(ctx context.Context, dep *appsv1.Deployment) error {
+	if !c.gatewayAPIAvailable() {
+		return nil
+	}
+	return c.deleteOwnedUnstructured(ctx, dep, httpRouteGVR, c.routeLister, "HTTPRoute")
+}
+
+// newHTTPRoute returns an HTTPRoute attached to the parent gateway, routing the configured host and path to the
+// first port of the service. TLS is terminated by the Gateway listener, so the tls-secret annotation is not used.
+func newHTTPRoute(dep *appsv1.Deployment, svc *corev1.Service, cfg exposeConfig) *unstructured.Unstructured {
+	// the defaults the api server would apply are set explicitly, otherwise the route would always look drifted
+	parentRef := map[string]any{
+		"group": httpRouteGVR.Group,
+		"kind":  "Gateway",
+		"name":  cfg.gateway.name,
+	}
+	if cfg.gateway.namespace != "" {
+		parentRef["namespace"] = cfg.gateway.namespace
+	}
+
+	spec := map[string]any{
+		"parentRefs": []any{parentRef},
+		"rules": []any{
+			map[string]any{
+				"matches": []any{
+					map[string]any{
+						"path": map[string]any{
+							"type":  httpRoutePathType(cfg.pathType),
+							"value": cfg.path,
+						},
+					},
+				},
+				"backendRefs": []any{
+					map[string]any{
+						"group":  "",
+						"kind":   "Service",
+						"name":   svc.Name,
+						"port":   int64(svc.Spec.Ports[0].Port),
+						"weight": int64(1),
+					},
+				},
+			},
+		},
+	}
+	if cfg.host != "" {
+		spec["hostnames"] = []any{cfg.host}
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	route.SetGroupVersionKind(httpRouteGVR.GroupVersion().WithKind("HTTPRoute"))
+	meta := newObjectMeta(dep)
+	route.SetName(meta.Name)
+	route.SetNamespace(meta.Namespace)
+	route.SetLabels(meta.Labels)
+	route.SetOwnerReferences(meta.OwnerReferences)
+	return route
+}
+
+// httpRoutePathType maps an Ingress path type to its HTTPRoute equivalent.
+func httpRoutePathType(pathType networkingv1.PathType) string {
+	if pathType == networkingv1.PathTypeExact {
+		return "Exact"
+	}
+	return "PathPrefix"
+}
+
+// isSubset reports whether every field of want is set to the same value in got. Lists must be the same length.
+func isSubset(want, got any) bool {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !isSubset(v, g[k]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !isSubset(w[i], g[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return equality.Semantic.DeepEqual(want, got)
+	}
+}
+
+// parseOutput validates an output mode given by flag or annotation.
+func parseOutput(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case outputIngress:
+		return outputIngress, nil
+	case outputHTTPRoute:
+		return outputHTTPRoute, nil
+	}
+	return "", fmt.Errorf("%q must be one of %s or %s", s, outputIngress, outputHTTPRoute)
+}
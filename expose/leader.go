@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// leaderElectionConfig configures the Lease used to elect a single active replica.
+type leaderElectionConfig struct {
+	enabled        bool
+	leaseName      string
+	leaseNamespace string
+	identity       string
+	leaseDuration  time.Duration
+	renewDeadline  time.Duration
+	retryPeriod    time.Duration
+}
+
+func (l *leaderElectionConfig) addFlags(fs *flag.FlagSet) {
+	hostname, _ := os.Hostname()
+	fs.BoolVar(&l.enabled, "leader-elect", true, "Elect a leader before running workers, required when running more than one replica.")
+	fs.StringVar(&l.leaseName, "leader-elect-lease-name", "expose-controller", "Name of the Lease used for leader election.")
+	fs.StringVar(&l.leaseNamespace, "leader-elect-lease-namespace", "default", "Namespace of the Lease used for leader election.")
+	fs.StringVar(&l.identity, "leader-elect-identity", hostname, "Identity of this replica, defaults to the hostname (the pod name in-cluster).")
+	fs.DurationVar(&l.leaseDuration, "lease-duration", 15*time.Second, "Duration non-leaders wait before trying to acquire the lease.")
+	fs.DurationVar(&l.renewDeadline, "renew-deadline", 10*time.Second, "Duration the leader retries renewing the lease before giving it up.")
+	fs.DurationVar(&l.retryPeriod, "retry-period", 2*time.Second, "Duration between attempts to acquire or renew the lease.")
+}
+
+// runWithLeaderElection blocks until ctx is cancelled, calling run once this replica becomes the leader. The lease is
+// released when ctx is cancelled so another replica can take over straight away rather than waiting for it to expire.
+// Losing the lease while ctx is still active returns an error, as the controller can't safely carry on. We wait for
+// run to return, the lease may already be held by another replica while it drains, which at worst repeats a reconcile.
+func runWithLeaderElection(ctx context.Context, clientset kubernetes.Interface, cfg leaderElectionConfig, run func(ctx context.Context) error) error {
+	lock := &acquiredLock{Interface: &resourcelock.LeaseLock{
+		LeaseMeta: v1.ObjectMeta{
+			Name:      cfg.leaseName,
+			Namespace: cfg.leaseNamespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: cfg.identity,
+		},
+	}}
+
+	// run is called from its own goroutine, done is closed once it has returned
+	done := make(chan struct{})
+	var runErr error
+	var lost bool
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   cfg.leaseDuration,
+		RenewDeadline:   cfg.renewDeadline,
+		RetryPeriod:     cfg.retryPeriod,
+		Name:            cfg.leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				defer close(done)
+				klog.FromContext(ctx).Info("Acquired the lease, starting workers", "identity", cfg.identity)
+				runErr = run(ctx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() == nil {
+					lost = true
+				}
+				klog.FromContext(ctx).Info("No longer the leader", "identity", cfg.identity)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != cfg.identity {
+					klog.FromContext(ctx).Info("New leader elected", "identity", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("configuring leader election: %w", err)
+	}
+
+	elector.Run(ctx)
+	// Run may return before the goroutine calling OnStartedLeading has been scheduled, whether it was started is
+	// known from the lock instead
+	if lock.acquired.Load() {
+		<-done
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if lost {
+		return fmt.Errorf("lost the lease %s/%s", cfg.leaseNamespace, cfg.leaseName)
+	}
+	return nil
+}
+
+// acquiredLock records whether this replica ever wrote itself into the lease as its holder. The elector only starts
+// leading after such a write.
+type acquiredLock struct {
+	resourcelock.Interface
+	acquired atomic.Bool
+}
+
+func (l *acquiredLock) Create(ctx context.Context, record resourcelock.LeaderElectionRecord) error {
+	err := l.Interface.Create(ctx, record)
+	l.record(record, err)
+	return err
+}
+
+func (l *acquiredLock) Update(ctx context.Context, record resourcelock.LeaderElectionRecord) error {
+	err := l.Interface.Update(ctx, record)
+	l.record(record, err)
+	return err
+}
+
+func (l *acquiredLock) record(record resourcelock.LeaderElectionRecord, err error) {
+	// releasing the lease writes an empty holder
+	if err == nil && record.HolderIdentity == l.Identity() {
+		l.acquired.Store(true)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testLeaderElectionConfig(identity string) leaderElectionConfig {
+	return leaderElectionConfig{
+		enabled:        true,
+		leaseName:      "expose-controller",
+		leaseNamespace: v1.NamespaceDefault,
+		identity:       identity,
+		leaseDuration:  time.Second,
+		renewDeadline:  500 * time.Millisecond,
+		retryPeriod:    100 * time.Millisecond,
+	}
+}
+
+// replica runs runWithLeaderElection in the background, run blocks until it is no longer the leader and then takes
+// a while to drain.
+type replica struct {
+	cancel   context.CancelFunc
+	started  chan struct{}
+	drained  atomic.Bool
+	returned chan error
+}
+
+func startReplica(clientset *fake.Clientset, identity string) *replica {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &replica{cancel: cancel, started: make(chan struct{}), returned: make(chan error, 1)}
+	go func() {
+		r.returned <- runWithLeaderElection(ctx, clientset, testLeaderElectionConfig(identity), func(ctx context.Context) error {
+			close(r.started)
+			<-ctx.Done()
+			time.Sleep(50 * time.Millisecond)
+			r.drained.Store(true)
+			return nil
+		})
+	}()
+	return r
+}
+
+func (r *replica) waitForStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica didn't become the leader")
+	}
+}
+
+func (r *replica) waitForReturned(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-r.returned:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWithLeaderElection didn't return")
+		return nil
+	}
+}
+
+func leaseHolder(t *testing.T, clientset *fake.Clientset) string {
+	t.Helper()
+	lease, err := clientset.CoordinationV1().Leases(v1.NamespaceDefault).Get(context.Background(), "expose-controller", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func TestLeaderElectionReleasesOnCancel(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	a := startReplica(clientset, "a")
+	a.waitForStarted(t)
+	if got := leaseHolder(t, clientset); got != "a" {
+		t.Fatalf("lease holder = %q, want a", got)
+	}
+
+	a.cancel()
+	if err := a.waitForReturned(t); err != nil {
+		t.Fatal(err)
+	}
+	if !a.drained.Load() {
+		t.Error("returned before run had drained")
+	}
+	if got := leaseHolder(t, clientset); got != "" {
+		t.Errorf("lease holder = %q, want the lease released", got)
+	}
+}
+
+func TestLeaderElectionTakeover(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	a := startReplica(clientset, "a")
+	a.waitForStarted(t)
+	b := startReplica(clientset, "b")
+	defer b.cancel()
+
+	// b waits while a holds the lease
+	select {
+	case <-b.started:
+		t.Fatal("b became the leader while a held the lease")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	a.cancel()
+	if err := a.waitForReturned(t); err != nil {
+		t.Fatal(err)
+	}
+	// the lease was released, b doesn't wait for it to expire
+	b.waitForStarted(t)
+	if got := leaseHolder(t, clientset); got != "b" {
+		t.Errorf("lease holder = %q, want b", got)
+	}
+}
+
+func TestLeaderElectionLost(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	// reactors can't be added while the clientset is in use, renewing fails once unavailable is set
+	var unavailable atomic.Bool
+	clientset.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if !unavailable.Load() {
+			return false, nil, nil
+		}
+		return true, nil, errors.New("api server unavailable")
+	})
+	a := startReplica(clientset, "a")
+	defer a.cancel()
+	a.waitForStarted(t)
+
+	unavailable.Store(true)
+	err := a.waitForReturned(t)
+	if err == nil {
+		t.Fatal("want an error once the lease can't be renewed")
+	}
+	if !a.drained.Load() {
+		t.Error("returned before run had drained")
+	}
+}
+
+func TestLeaderElectionCancelledBeforeAcquiring(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	a := startReplica(clientset, "a")
+	defer a.cancel()
+	a.waitForStarted(t)
+
+	// b never acquires the lease, it returns without waiting for a run that was never started
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	err := runWithLeaderElection(ctx, clientset, testLeaderElectionConfig("b"), func(ctx context.Context) error {
+		t.Error("b started leading while a held the lease")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/klog/v2"
+	"math"
+	"os"
+)
+
+// Log formats, selected with the --log-format flag.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// loggingConfig configures klog, verbosity is set with the klog flags, e.g. -v=2 logs every reconcile and -v=4
+// every informer event.
+type loggingConfig struct {
+	format string
+}
+
+func (l *loggingConfig) addFlags(fs *flag.FlagSet) {
+	klog.InitFlags(fs)
+	fs.StringVar(&l.format, "log-format", logFormatText, "Log format, text or json (one object per line for log pipelines).")
+}
+
+// setup replaces the klog text output with JSON when asked to. The -v and -vmodule flags are applied by klog before
+// a line reaches the logger, so the JSON logger itself doesn't filter by verbosity.
+func (l *loggingConfig) setup() error {
+	switch l.format {
+	case logFormatText:
+		return nil
+	case logFormatJSON:
+		klog.SetLogger(funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(os.Stderr, obj)
+		}, funcr.Options{
+			LogTimestamp: true,
+			Verbosity:    math.MaxInt32,
+		}))
+		return nil
+	}
+	return fmt.Errorf("%q must be one of %s or %s", l.format, logFormatText, logFormatJSON)
+}
@@ -1,35 +1,259 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	informers2 "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
 func main() {
 	kubeCfg := flag.String("kubeconfig", "~/.kube/config", "Kubeconfig location.")
+	output := flag.String("output", outputIngress, "Resource generated for each deployment, ingress or httproute. Overridden by the output annotation.")
+	gateway := flag.String("gateway", "", "Parent Gateway of generated HTTPRoutes as name or namespace/name. Overridden by the gateway annotation.")
+	tlsMode := flag.String("tls-mode", tlsModeCertificate, "How certificates are requested from cert-manager for the tls-issuer annotation, certificate or ingress-shim.")
+	dryRun := flag.String("dry-run", dryRunNone, "Print the changes for every deployment once and exit: none, client (nothing is sent), or server (sent with DryRun: All).")
+	planFormat := flag.String("dry-run-output", planFormatYAML, "Format of the changes printed in dry-run mode, yaml or diff.")
+	metricsAddr := flag.String("metrics-addr", ":8080", "Address to serve /metrics, /healthz and /readyz on.")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Time allowed for in-flight reconciles to finish after SIGINT or SIGTERM.")
+	var le leaderElectionConfig
+	le.addFlags(flag.CommandLine)
+	var sc scope
+	sc.addFlags(flag.CommandLine)
+	var lc loggingConfig
+	lc.addFlags(flag.CommandLine)
+	flag.Parse()
+	defer klog.Flush()
+
+	if err := lc.setup(); err != nil {
+		fatal(err, "Invalid flag", "flag", "log-format")
+	}
+	if err := sc.validate(); err != nil {
+		fatal(err, "Invalid scope")
+	}
+
+	defaultOutput, err := parseOutput(*output)
+	if err != nil {
+		fatal(err, "Invalid flag", "flag", "output")
+	}
+	*dryRun, err = parseDryRun(*dryRun)
+	if err != nil {
+		fatal(err, "Invalid flag", "flag", "dry-run")
+	}
+	*planFormat, err = parsePlanFormat(*planFormat)
+	if err != nil {
+		fatal(err, "Invalid flag", "flag", "dry-run-output")
+	}
+	*tlsMode, err = parseTLSMode(*tlsMode)
+	if err != nil {
+		fatal(err, "Invalid flag", "flag", "tls-mode")
+	}
+	var defaultGateway parentGateway
+	if *gateway != "" {
+		defaultGateway, err = parseParentGateway(*gateway)
+		if err != nil {
+			fatal(err, "Invalid flag", "flag", "gateway")
+		}
+	}
+
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeCfg)
 	if err != nil {
-		fmt.Printf("error building config from files %s\n", err)
+		klog.InfoS("Failed to build config from kubeconfig, trying in-cluster config", "err", err)
 		config, err = rest.InClusterConfig()
 		if err != nil {
-			fmt.Printf("failed to get incluster config %s\n", err)
-			os.Exit(1)
+			fatal(err, "Failed to get in-cluster config")
 		}
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		fmt.Printf("failed when attempting to build clientset %s ", err)
-		os.Exit(1)
+		fatal(err, "Failed to build clientset")
+	}
+
+	if err := checkServedResources(clientset.Discovery()); err != nil {
+		fatal(err, "Cluster does not support the expose controller")
+	}
+
+	// the Gateway API is optional, without it only Ingresses are generated
+	gatewayErr := checkServedResource(clientset.Discovery(), httpRouteGVR)
+	if gatewayErr != nil && defaultOutput == outputHTTPRoute {
+		fatal(gatewayErr, "The httproute output requires the Gateway API")
+	}
+	// as is cert-manager, without it the tls-issuer annotation can't be used
+	certManagerErr := checkServedResource(clientset.Discovery(), certificateGVR)
+
+	// cancelled on SIGINT or SIGTERM, so that the queue is drained and the lease released before the process exits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// a second signal kills the process straight away
+		stop()
+	}()
+
+	var dynamicClient dynamic.Interface
+	if gatewayErr == nil || certManagerErr == nil {
+		dynamicClient, err = dynamic.NewForConfig(config)
+		if err != nil {
+			fatal(err, "Failed to build dynamic client")
+		}
+	}
+	if gatewayErr != nil {
+		klog.InfoS("Gateway API not found, httproute output is disabled", "err", gatewayErr)
+	}
+	if certManagerErr != nil {
+		klog.InfoS("cert-manager not found, the tls-issuer annotation is disabled", "err", certManagerErr)
+	}
+
+	// a controller is run for each watched namespace, with its own informers
+	var controllers []*controller
+	var factories []informerFactory
+	for _, ns := range sc.watchNamespaces() {
+		depInformers := informers2.NewSharedInformerFactoryWithOptions(clientset, 10*time.Minute, sc.deploymentOptions(ns)...)
+		ownedInformers := informers2.NewSharedInformerFactoryWithOptions(clientset, 10*time.Minute, sc.ownedOptions(ns)...)
+		c := newController(
+			clientset,
+			ns,
+			depInformers.Apps().V1().Deployments(),
+			ownedInformers.Core().V1().Services(),
+			ownedInformers.Networking().V1().Ingresses(),
+		)
+		c.fieldSelector = sc.fieldSelector()
+		c.defaultOutput = defaultOutput
+		c.defaultGateway = defaultGateway
+		c.tlsMode = *tlsMode
+		c.dryRun = *dryRun
+		c.planFormat = *planFormat
+		c.shutdownTimeout = *shutdownTimeout
+		factories = append(factories, depInformers, ownedInformers)
+
+		if dynamicClient != nil {
+			dynamicInformers := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 10*time.Minute, ns, sc.tweakOwned)
+			if gatewayErr == nil {
+				c.withGatewayAPI(dynamicClient, dynamicInformers.ForResource(httpRouteGVR))
+			}
+			if certManagerErr == nil {
+				c.withCertManager(dynamicClient, dynamicInformers.ForResource(certificateGVR))
+			}
+			factories = append(factories, dynamicInformers)
+		}
+		controllers = append(controllers, c)
+	}
+
+	server := newMetricsServer(*metricsAddr, func() bool {
+		for _, c := range controllers {
+			if !c.hasSynced() {
+				return false
+			}
+		}
+		return true
+	})
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal(err, "Metrics server failed")
+		}
+	}()
+
+	// every replica keeps its caches warm, only the leader runs workers
+	for _, f := range factories {
+		f.Start(ctx.Done())
+	}
+	for _, c := range controllers {
+		if !c.waitForCacheSync(ctx.Done()) {
+			fatal(fmt.Errorf("timed out waiting for cache to sync"), "Failed to start")
+		}
+	}
+
+	// a dry-run plans against the informer cache once, it doesn't need to be the leader as nothing is applied
+	if *dryRun != dryRunNone {
+		for _, c := range controllers {
+			if err := c.plan(ctx); err != nil {
+				fatal(err, "Dry-run failed")
+			}
+		}
+		return
+	}
+
+	if le.enabled {
+		err = runWithLeaderElection(ctx, clientset, le, func(ctx context.Context) error {
+			return runControllers(ctx, controllers)
+		})
+	} else {
+		err = runControllers(ctx, controllers)
+	}
+
+	// the informers and the metrics server are stopped once the workers have finished
+	shutdown(server, factories)
+	switch {
+	case errors.Is(err, errDrainTimeout):
+		klog.ErrorS(err, "Shut down before in-flight reconciles finished")
+		klog.FlushAndExit(klog.ExitFlushTimeout, exitDrainTimeout)
+	case err != nil:
+		fatal(err, "Controller failed")
 	}
+	klog.InfoS("Shut down cleanly")
+}
+
+// fatal logs an error which stops the controller and exits.
+func fatal(err error, msg string, keysAndValues ...any) {
+	klog.ErrorSDepth(1, err, msg, keysAndValues...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, exitError)
+}
+
+// Exit codes, so that a supervisor can tell a clean shutdown from one which left reconciles unfinished.
+const (
+	exitError        = 1
+	exitDrainTimeout = 2
+)
+
+// informerFactory is implemented by both the typed and the dynamic shared informer factories.
+type informerFactory interface {
+	Start(stopCh <-chan struct{})
+	Shutdown()
+}
 
-	ch := make(chan struct{})
-	informers := informers2.NewSharedInformerFactory(clientset, 10*time.Minute)
+// runControllers runs every controller until ctx is cancelled, returning the first error.
+func runControllers(ctx context.Context, controllers []*controller) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(controllers))
+	for i, c := range controllers {
+		wg.Add(1)
+		go func(i int, c *controller) {
+			defer wg.Done()
+			errs[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
 
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shutdown stops the metrics server and waits for the informers, whose stop channel is already closed, to exit.
+func shutdown(server *http.Server, factories []informerFactory) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		klog.ErrorS(err, "Failed to stop metrics server")
+	}
+	for _, f := range factories {
+		f.Shutdown()
+	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+// deleteOrphans removes managed Services, Ingresses, HTTPRoutes and Certificates whose owning deployment no longer
+// exists. The garbage collector normally takes care of this, but resources created before owner references were set,
+// or whose owner was deleted with --cascade=orphan, would otherwise be left behind.
+func (c *controller) deleteOrphans(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
+	selector := v1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue,
+		FieldSelector: c.fieldSelector,
+	}
+
+	services, err := c.clientset.CoreV1().Services(c.namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("listing services: %w", err)
+	}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if c.hasOwner(ctx, svc.ObjectMeta) {
+			continue
+		}
+		logger.Info("Deleting orphaned service", "service", klog.KObj(svc))
+		err := c.write("delete", svc, nil, func() error {
+			return c.clientset.CoreV1().Services(svc.Namespace).Delete(ctx, svc.Name, c.deleteOptions())
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting service: %w", err)
+		}
+	}
+
+	ingresses, err := c.clientset.NetworkingV1().Ingresses(c.namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("listing ingresses: %w", err)
+	}
+	for i := range ingresses.Items {
+		ing := &ingresses.Items[i]
+		if c.hasOwner(ctx, ing.ObjectMeta) {
+			continue
+		}
+		logger.Info("Deleting orphaned ingress", "ingress", klog.KObj(ing))
+		err := c.write("delete", ing, nil, func() error {
+			return c.clientset.NetworkingV1().Ingresses(ing.Namespace).Delete(ctx, ing.Name, c.deleteOptions())
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting ingress: %w", err)
+		}
+	}
+
+	if c.gatewayAPIAvailable() {
+		if err := c.deleteOrphanedUnstructured(ctx, httpRouteGVR, selector); err != nil {
+			return err
+		}
+	}
+	if c.certManagerAvailable() {
+		if err := c.deleteOrphanedUnstructured(ctx, certificateGVR, selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrphanedUnstructured removes orphans of a resource we only know through the dynamic client.
+func (c *controller) deleteOrphanedUnstructured(ctx context.Context, gvr schema.GroupVersionResource, selector v1.ListOptions) error {
+	logger := klog.FromContext(ctx)
+	items, err := c.dynamicClient.Resource(gvr).Namespace(c.namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", gvr.Resource, err)
+	}
+	for i := range items.Items {
+		item := &items.Items[i]
+		if c.hasOwner(ctx, v1.ObjectMeta{Namespace: item.GetNamespace(), OwnerReferences: item.GetOwnerReferences()}) {
+			continue
+		}
+		logger.Info("Deleting orphaned resource", "resource", gvr.Resource, "object", klog.KObj(item))
+		err := c.write("delete", item, nil, func() error {
+			return c.dynamicClient.Resource(gvr).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), c.deleteOptions())
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting %s: %w", gvr.Resource, err)
+		}
+	}
+	return nil
+}
+
+// hasOwner reports whether the controller owner reference of a generated resource still points at
+// an existing deployment, the UID is compared so a recreated deployment of the same name doesn't count.
+// Deployments outside the --selector aren't cached, so a cache miss is confirmed with the api server.
+func (c *controller) hasOwner(ctx context.Context, meta v1.ObjectMeta) bool {
+	ref := v1.GetControllerOf(&meta)
+	if ref == nil || ref.Kind != "Deployment" {
+		return false
+	}
+
+	dep, err := c.deploymentLister.Deployments(meta.Namespace).Get(ref.Name)
+	if errors.IsNotFound(err) {
+		dep, err = c.clientset.AppsV1().Deployments(meta.Namespace).Get(ctx, ref.Name, v1.GetOptions{})
+	}
+	if errors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		// when in doubt keep the resource, it is checked again on the next start
+		return true
+	}
+	return dep.UID == ref.UID
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+)
+
+const (
+	// managedByLabel marks the Services and Ingresses created by this controller.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "expose"
+)
+
+// newService returns a ClusterIP Service exposing the container ports of the deployment, either every port or
+// only the named port from the config. A port declared by more than one container is exposed once.
+func newService(dep *appsv1.Deployment, cfg exposeConfig) *corev1.Service {
+	type portKey struct {
+		port     int32
+		protocol corev1.Protocol
+	}
+	seen := map[portKey]bool{}
+
+	var ports []corev1.ServicePort
+	for _, container := range dep.Spec.Template.Spec.Containers {
+		for _, p := range container.Ports {
+			if cfg.port != "" && p.Name != cfg.port {
+				continue
+			}
+			// the protocol is defaulted the same way as the api server, otherwise it would always look drifted
+			protocol := p.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			key := portKey{port: p.ContainerPort, protocol: protocol}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ports = append(ports, corev1.ServicePort{
+				Name:       servicePortName(container, p, protocol),
+				Port:       p.ContainerPort,
+				TargetPort: intstr.FromInt(int(p.ContainerPort)),
+				Protocol:   protocol,
+			})
+		}
+	}
+
+	return &corev1.Service{
+		ObjectMeta: newObjectMeta(dep),
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: selectorLabels(dep),
+			Ports:    ports,
+		},
+	}
+}
+
+// newIngress returns an Ingress routing the configured host and path to the first port of the service.
+func newIngress(dep *appsv1.Deployment, svc *corev1.Service, cfg exposeConfig) *networkingv1.Ingress {
+	pathType := cfg.pathType
+	ing := &networkingv1.Ingress{
+		ObjectMeta: newObjectMeta(dep),
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: cfg.ingressClassName,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: cfg.host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     cfg.path,
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: svc.Name,
+											Port: networkingv1.ServiceBackendPort{
+												Number: svc.Spec.Ports[0].Port,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if cfg.tlsSecret != "" {
+		tls := networkingv1.IngressTLS{SecretName: cfg.tlsSecret}
+		if cfg.host != "" {
+			tls.Hosts = []string{cfg.host}
+		}
+		ing.Spec.TLS = []networkingv1.IngressTLS{tls}
+	}
+
+	return ing
+}
+
+// newObjectMeta returns the metadata shared by the generated resources. The controller owner reference
+// lets the garbage collector remove them once the deployment is deleted.
+func newObjectMeta(dep *appsv1.Deployment) v1.ObjectMeta {
+	labels := selectorLabels(dep)
+	labels[managedByLabel] = managedByValue
+
+	return v1.ObjectMeta{
+		Name:      dep.Name,
+		Namespace: dep.Namespace,
+		Labels:    labels,
+		OwnerReferences: []v1.OwnerReference{
+			*v1.NewControllerRef(dep, appsv1.SchemeGroupVersion.WithKind("Deployment")),
+		},
+	}
+}
+
+// selectorLabels returns the matchLabels of the deployment selector. The pod template labels may change during a
+// rollout, e.g. a version label, a service selecting on them would drop the old pods before the new ones are ready.
+func selectorLabels(dep *appsv1.Deployment) map[string]string {
+	labels := map[string]string{}
+	if dep.Spec.Selector != nil {
+		for k, v := range dep.Spec.Selector.MatchLabels {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// servicePortName names a service port after the container port, service ports must be named
+// when a service exposes more than one port. The protocol tells apart e.g. 53/TCP and 53/UDP.
+func servicePortName(container corev1.Container, p corev1.ContainerPort, protocol corev1.Protocol) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s-%d-%s", container.Name, p.ContainerPort, strings.ToLower(string(protocol)))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"reflect"
+	"testing"
+)
+
+func TestNewServicePorts(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []corev1.Container
+		port       string
+		want       []string
+	}{
+		{
+			name: "named ports keep their name",
+			containers: []corev1.Container{
+				{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "metrics", ContainerPort: 9090}}},
+			},
+			want: []string{"http/8080/TCP", "metrics/9090/TCP"},
+		},
+		{
+			name: "unnamed ports are named after container, port and protocol",
+			containers: []corev1.Container{
+				{Name: "dns", Ports: []corev1.ContainerPort{{ContainerPort: 53}, {ContainerPort: 53, Protocol: corev1.ProtocolUDP}}},
+			},
+			want: []string{"dns-53-tcp/53/TCP", "dns-53-udp/53/UDP"},
+		},
+		{
+			name: "a port declared twice is exposed once",
+			containers: []corev1.Container{
+				{Name: "web", Ports: []corev1.ContainerPort{{ContainerPort: 8080}, {ContainerPort: 8080, Protocol: corev1.ProtocolTCP}}},
+				{Name: "sidecar", Ports: []corev1.ContainerPort{{ContainerPort: 8080}, {ContainerPort: 8081}}},
+			},
+			want: []string{"web-8080-tcp/8080/TCP", "sidecar-8081-tcp/8081/TCP"},
+		},
+		{
+			name: "only the configured port",
+			containers: []corev1.Container{
+				{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "metrics", ContainerPort: 9090}}},
+			},
+			port: "metrics",
+			want: []string{"metrics/9090/TCP"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := newDeployment("web", enabled())
+			dep.Spec.Template.Spec.Containers = tt.containers
+
+			var got []string
+			for _, p := range newService(dep, exposeConfig{port: tt.port}).Spec.Ports {
+				if p.TargetPort.IntValue() != int(p.Port) {
+					t.Errorf("port %s targets %s, want %d", p.Name, p.TargetPort.String(), p.Port)
+				}
+				got = append(got, fmt.Sprintf("%s/%d/%s", p.Name, p.Port, p.Protocol))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ports = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
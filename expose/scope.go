@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"strings"
+)
+
+// scope limits the deployments the controller watches, caches and reconciles. A controller is run for each
+// namespace, so RBAC can use a Role per namespace rather than a ClusterRole.
+type scope struct {
+	namespaces        stringSlice
+	excludeNamespaces stringSlice
+	selector          string
+}
+
+func (s *scope) addFlags(fs *flag.FlagSet) {
+	fs.Var(&s.namespaces, "namespace", "Namespace to watch, may be repeated. All namespaces are watched when not set.")
+	fs.Var(&s.excludeNamespaces, "exclude-namespace", "Namespace to ignore when watching all namespaces, may be repeated.")
+	fs.StringVar(&s.selector, "selector", "", "Label selector of the deployments to watch, e.g. team=web.")
+}
+
+func (s *scope) validate() error {
+	if len(s.namespaces) > 0 && len(s.excludeNamespaces) > 0 {
+		return fmt.Errorf("--namespace and --exclude-namespace can't be used together")
+	}
+	if _, err := labels.Parse(s.selector); err != nil {
+		return fmt.Errorf("invalid --selector: %w", err)
+	}
+	return nil
+}
+
+// watchNamespaces returns the namespaces a controller is run for, v1.NamespaceAll when no namespace is set.
+func (s *scope) watchNamespaces() []string {
+	if len(s.namespaces) == 0 {
+		return []string{v1.NamespaceAll}
+	}
+	return s.namespaces
+}
+
+// deploymentOptions returns the informer factory options used for deployments in a namespace.
+func (s *scope) deploymentOptions(namespace string) []informers.SharedInformerOption {
+	return []informers.SharedInformerOption{
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *v1.ListOptions) {
+			o.LabelSelector = s.selector
+			o.FieldSelector = s.fieldSelector()
+		}),
+	}
+}
+
+// ownedOptions returns the informer factory options used for the resources we generate, only those with the
+// managed-by label are cached.
+func (s *scope) ownedOptions(namespace string) []informers.SharedInformerOption {
+	return []informers.SharedInformerOption{
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(s.tweakOwned),
+	}
+}
+
+func (s *scope) tweakOwned(o *v1.ListOptions) {
+	o.LabelSelector = managedByLabel + "=" + managedByValue
+	o.FieldSelector = s.fieldSelector()
+}
+
+// fieldSelector excludes namespaces, metadata.namespace is supported as a field selector by every resource.
+func (s *scope) fieldSelector() string {
+	var selectors []fields.Selector
+	for _, ns := range s.excludeNamespaces {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", ns))
+	}
+	return fields.AndSelectors(selectors...).String()
+}
+
+// stringSlice is a flag which may be repeated, or given as a comma separated list.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*s = append(*s, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"time"
+)
+
+// statusAnnotation is written back to a deployment with the outcome of the last sync, it shows in kubectl describe.
+const statusAnnotation = annotationPrefix + "status"
+
+// statusRefreshInterval limits how often lastSync is refreshed when nothing else changed, a deployment is synced
+// on every status update of its own during a rollout.
+const statusRefreshInterval = time.Minute
+
+// Results of a sync, written to the status annotation.
+const (
+	resultSynced  = "Synced"
+	resultSkipped = "Skipped"
+	resultFailed  = "Failed"
+)
+
+// exposeStatus is the value of the status annotation.
+type exposeStatus struct {
+	Result   string `json:"result"`
+	Message  string `json:"message,omitempty"`
+	Output   string `json:"output,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Path     string `json:"path,omitempty"`
+	LastSync string `json:"lastSync,omitempty"`
+}
+
+func syncedStatus(cfg exposeConfig) *exposeStatus {
+	return &exposeStatus{Result: resultSynced, Output: cfg.output, Host: cfg.host, Path: cfg.path}
+}
+
+func failedStatus(err error) *exposeStatus {
+	return &exposeStatus{Result: resultFailed, Message: err.Error()}
+}
+
+// updateStatus patches the status annotation of a deployment, a nil status removes it. A merge patch is used
+// so that we don't conflict with the deployment controller updating the same object.
+func (c *controller) updateStatus(ctx context.Context, dep *appsv1.Deployment, status *exposeStatus) error {
+	// a dry-run doesn't touch the deployment
+	if c.dryRun != dryRunNone {
+		return nil
+	}
+
+	current, ok := dep.Annotations[statusAnnotation]
+	var value any
+	if status == nil {
+		if !ok {
+			return nil
+		}
+	} else {
+		now := c.clock.Now()
+		if !statusNeedsUpdate(current, *status, now) {
+			return nil
+		}
+		status.LastSync = now.UTC().Format(time.RFC3339)
+		b, err := json.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("encoding status: %w", err)
+		}
+		value = string(b)
+	}
+
+	// a null value removes the annotation
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{statusAnnotation: value},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("encoding status patch: %w", err)
+	}
+	_, err = c.clientset.AppsV1().Deployments(dep.Namespace).Patch(ctx, dep.Name, types.MergePatchType, patch, v1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("updating status annotation: %w", err)
+	}
+	return nil
+}
+
+// statusNeedsUpdate reports whether the status differs from the current annotation, or lastSync is stale.
+func statusNeedsUpdate(current string, status exposeStatus, now time.Time) bool {
+	var existing exposeStatus
+	if err := json.Unmarshal([]byte(current), &existing); err != nil {
+		return true
+	}
+	lastSync, err := time.Parse(time.RFC3339, existing.LastSync)
+	if err != nil || now.Sub(lastSync) >= statusRefreshInterval {
+		return true
+	}
+	existing.LastSync = ""
+	status.LastSync = ""
+	return existing != status
+}
+
+// onlyStatusChanged reports whether an update to a deployment was our own write of the status annotation, which
+// must not enqueue the deployment again.
+func onlyStatusChanged(old, new *appsv1.Deployment) bool {
+	// a resync delivers the same object, it is handled like any other update
+	if old.ResourceVersion == new.ResourceVersion {
+		return false
+	}
+	if old.Annotations[statusAnnotation] == new.Annotations[statusAnnotation] {
+		return false
+	}
+
+	o, n := old.DeepCopy(), new.DeepCopy()
+	for _, d := range []*appsv1.Deployment{o, n} {
+		delete(d.Annotations, statusAnnotation)
+		d.ResourceVersion = ""
+		d.ManagedFields = nil
+	}
+	return equality.Semantic.DeepEqual(o, n)
+}
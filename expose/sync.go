@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// sync ensures the Service and the Ingress, or HTTPRoute, for the given deployment match the desired state,
+// deployments which haven't opted in through the enabled annotation have their generated resources removed.
+// The outcome is written back to the status annotation of the deployment.
+func (c *controller) sync(ctx context.Context, dep *appsv1.Deployment) error {
+	status, err := c.reconcile(ctx, dep)
+	if err != nil {
+		status = failedStatus(err)
+	}
+	if statusErr := c.updateStatus(ctx, dep, status); statusErr != nil && err == nil {
+		err = statusErr
+	}
+	return err
+}
+
+// reconcile does the work of sync, returning the status to record. A nil status removes the status annotation.
+func (c *controller) reconcile(ctx context.Context, dep *appsv1.Deployment) (*exposeStatus, error) {
+	enabled, err := isEnabled(dep)
+	if err != nil {
+		c.recordAnnotationError(ctx, dep, err)
+		return failedStatus(err), nil
+	}
+	if !enabled {
+		return nil, c.deleteOwned(ctx, dep)
+	}
+
+	cfg, err := parseExposeConfig(dep)
+	if err != nil {
+		c.recordAnnotationError(ctx, dep, err)
+		return failedStatus(err), nil
+	}
+
+	if cfg.output == "" {
+		cfg.output = c.defaultOutput
+	}
+	if cfg.gateway.name == "" {
+		cfg.gateway = c.defaultGateway
+	}
+	if cfg.tlsIssuer.name != "" && cfg.output == outputIngress && !c.certManagerAvailable() {
+		msg := fmt.Sprintf("cert-manager is not installed, no certificate can be issued for the %s annotation", tlsIssuerAnnotation)
+		c.recordWarning(ctx, dep, "CertManagerUnavailable", msg)
+		return &exposeStatus{Result: resultFailed, Message: msg}, nil
+	}
+	if cfg.output == outputHTTPRoute {
+		if !c.gatewayAPIAvailable() {
+			msg := "the Gateway API is not installed, no HTTPRoute can be created"
+			c.recordWarning(ctx, dep, "GatewayAPIUnavailable", msg)
+			return &exposeStatus{Result: resultFailed, Message: msg}, nil
+		}
+		if cfg.gateway.name == "" {
+			msg := fmt.Sprintf("set the %s annotation or the --gateway flag", gatewayAnnotation)
+			c.recordWarning(ctx, dep, "GatewayNotSet", msg)
+			return &exposeStatus{Result: resultFailed, Message: msg}, nil
+		}
+	}
+
+	// a service can't select on expressions, a deployment selecting its pods only through them can't be exposed
+	if dep.Spec.Selector == nil || len(dep.Spec.Selector.MatchLabels) == 0 {
+		msg := "the deployment selector has no matchLabels, a service can only select pods by label"
+		c.recordWarning(ctx, dep, "SelectorUnsupported", msg)
+		return &exposeStatus{Result: resultFailed, Message: msg}, nil
+	}
+
+	svc := newService(dep, cfg)
+	if len(svc.Spec.Ports) == 0 {
+		klog.FromContext(ctx).V(2).Info("Deployment has no container ports, skipping")
+		return &exposeStatus{Result: resultSkipped, Message: "no container ports to expose"}, c.deleteOwned(ctx, dep)
+	}
+
+	if err := c.syncService(ctx, dep, svc); err != nil {
+		return nil, err
+	}
+
+	// only one of the ingress and the route exists, the other is removed when the output mode changes
+	if cfg.output == outputHTTPRoute {
+		if err := c.syncHTTPRoute(ctx, dep, newHTTPRoute(dep, svc, cfg)); err != nil {
+			return nil, err
+		}
+		// TLS is terminated by the Gateway listener, a certificate generated for a previous Ingress isn't needed
+		if err := c.deleteOwnedCertificate(ctx, dep); err != nil {
+			return nil, err
+		}
+		return syncedStatus(cfg), c.deleteOwnedIngress(ctx, dep)
+	}
+
+	ing := newIngress(dep, svc, cfg)
+	if cfg.tlsIssuer.name != "" && c.tlsMode == tlsModeIngressShim {
+		withIngressShim(ing, cfg.tlsIssuer)
+	}
+	if err := c.syncIngress(ctx, dep, ing); err != nil {
+		return nil, err
+	}
+	notReady, err := c.syncTLS(ctx, dep, cfg)
+	if err != nil {
+		return nil, err
+	}
+	status := syncedStatus(cfg)
+	status.Message = notReady
+	return status, c.deleteOwnedHTTPRoute(ctx, dep)
+}
+
+// syncService creates the service, or updates it when the fields we own have drifted from the desired state.
+func (c *controller) syncService(ctx context.Context, dep *appsv1.Deployment, desired *corev1.Service) error {
+	existing, err := c.serviceLister.Services(desired.Namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		err = c.write("create", nil, desired, func() error {
+			_, err := c.clientset.CoreV1().Services(desired.Namespace).Create(ctx, desired, c.createOptions())
+			return err
+		})
+		switch {
+		case errors.IsAlreadyExists(err):
+			// only services with the managed-by label are cached, one created by someone else or whose label was
+			// removed is read from the api server instead
+			existing, err = c.clientset.CoreV1().Services(desired.Namespace).Get(ctx, desired.Name, v1.GetOptions{})
+		case err != nil:
+			return fmt.Errorf("creating service: %w", err)
+		default:
+			c.recordNormal(ctx, dep, "ServiceCreated", fmt.Sprintf("created service %s", desired.Name))
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("getting service: %w", err)
+	}
+
+	if !v1.IsControlledBy(existing, dep) {
+		return fmt.Errorf("service %s/%s already exists and is not managed by expose", existing.Namespace, existing.Name)
+	}
+	if !serviceNeedsUpdate(existing, desired) {
+		return nil
+	}
+
+	// the service is copied so that fields set by the api server, e.g. the cluster IP, are kept
+	updated := existing.DeepCopy()
+	updated.Labels = mergeLabels(updated.Labels, desired.Labels)
+	updated.Spec.Type = desired.Spec.Type
+	updated.Spec.Selector = desired.Spec.Selector
+	updated.Spec.Ports = desired.Spec.Ports
+	err = c.write("update", existing, updated, func() error {
+		_, err := c.clientset.CoreV1().Services(updated.Namespace).Update(ctx, updated, c.updateOptions())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("updating service: %w", err)
+	}
+	c.recordNormal(ctx, dep, "ServiceUpdated", fmt.Sprintf("updated drifted service %s", updated.Name))
+	return nil
+}
+
+// syncIngress creates the ingress, or updates it when it has drifted from the desired state.
+func (c *controller) syncIngress(ctx context.Context, dep *appsv1.Deployment, desired *networkingv1.Ingress) error {
+	existing, err := c.ingressLister.Ingresses(desired.Namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		err = c.write("create", nil, desired, func() error {
+			_, err := c.clientset.NetworkingV1().Ingresses(desired.Namespace).Create(ctx, desired, c.createOptions())
+			return err
+		})
+		switch {
+		case errors.IsAlreadyExists(err):
+			// not cached, see syncService
+			existing, err = c.clientset.NetworkingV1().Ingresses(desired.Namespace).Get(ctx, desired.Name, v1.GetOptions{})
+		case err != nil:
+			return fmt.Errorf("creating ingress: %w", err)
+		default:
+			c.recordNormal(ctx, dep, "IngressCreated", fmt.Sprintf("created ingress %s", desired.Name))
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("getting ingress: %w", err)
+	}
+
+	if !v1.IsControlledBy(existing, dep) {
+		return fmt.Errorf("ingress %s/%s already exists and is not managed by expose", existing.Namespace, existing.Name)
+	}
+	if !ingressNeedsUpdate(existing, desired) {
+		return nil
+	}
+
+	updated := existing.DeepCopy()
+	updated.Labels = mergeLabels(updated.Labels, desired.Labels)
+	updated.Annotations = mergeIngressShimAnnotations(updated.Annotations, desired.Annotations)
+	updated.Spec.Rules = desired.Spec.Rules
+	updated.Spec.TLS = desired.Spec.TLS
+	// without the ingress-class annotation the class is left to the default IngressClass admission plugin
+	if desired.Spec.IngressClassName != nil {
+		updated.Spec.IngressClassName = desired.Spec.IngressClassName
+	}
+	err = c.write("update", existing, updated, func() error {
+		_, err := c.clientset.NetworkingV1().Ingresses(updated.Namespace).Update(ctx, updated, c.updateOptions())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("updating ingress: %w", err)
+	}
+	c.recordNormal(ctx, dep, "IngressUpdated", fmt.Sprintf("updated drifted ingress %s", updated.Name))
+	return nil
+}
+
+// deleteOwned removes the resources generated for a deployment which is no longer exposed.
+func (c *controller) deleteOwned(ctx context.Context, dep *appsv1.Deployment) error {
+	if err := c.deleteOwnedIngress(ctx, dep); err != nil {
+		return err
+	}
+	if err := c.deleteOwnedHTTPRoute(ctx, dep); err != nil {
+		return err
+	}
+	if err := c.deleteOwnedCertificate(ctx, dep); err != nil {
+		return err
+	}
+	return c.deleteOwnedService(ctx, dep)
+}
+
+func (c *controller) deleteOwnedIngress(ctx context.Context, dep *appsv1.Deployment) error {
+	ing, err := c.ingressLister.Ingresses(dep.Namespace).Get(dep.Name)
+	if err == nil && v1.IsControlledBy(ing, dep) {
+		err = c.write("delete", ing, nil, func() error {
+			return c.clientset.NetworkingV1().Ingresses(dep.Namespace).Delete(ctx, dep.Name, c.deleteOptions())
+		})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("deleting ingress: %w", err)
+		}
+		c.recordNormal(ctx, dep, "IngressDeleted", fmt.Sprintf("deleted ingress %s", ing.Name))
+	}
+	return nil
+}
+
+func (c *controller) deleteOwnedService(ctx context.Context, dep *appsv1.Deployment) error {
+	svc, err := c.serviceLister.Services(dep.Namespace).Get(dep.Name)
+	if err == nil && v1.IsControlledBy(svc, dep) {
+		err = c.write("delete", svc, nil, func() error {
+			return c.clientset.CoreV1().Services(dep.Namespace).Delete(ctx, dep.Name, c.deleteOptions())
+		})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("deleting service: %w", err)
+		}
+		c.recordNormal(ctx, dep, "ServiceDeleted", fmt.Sprintf("deleted service %s", svc.Name))
+	}
+
+	return nil
+}
+
+// serviceNeedsUpdate compares only the fields we set, the api server defaults others such as the cluster IP.
+func serviceNeedsUpdate(existing, desired *corev1.Service) bool {
+	return !hasLabels(existing.Labels, desired.Labels) ||
+		existing.Spec.Type != desired.Spec.Type ||
+		!equality.Semantic.DeepEqual(existing.Spec.Selector, desired.Spec.Selector) ||
+		!equality.Semantic.DeepEqual(ownedServicePorts(existing.Spec.Ports), desired.Spec.Ports)
+}
+
+// ingressNeedsUpdate compares only the fields we set, an unset class may have been defaulted by the api server.
+func ingressNeedsUpdate(existing, desired *networkingv1.Ingress) bool {
+	return !hasLabels(existing.Labels, desired.Labels) ||
+		!hasIngressShimAnnotations(existing.Annotations, desired.Annotations) ||
+		(desired.Spec.IngressClassName != nil && !equality.Semantic.DeepEqual(existing.Spec.IngressClassName, desired.Spec.IngressClassName)) ||
+		!equality.Semantic.DeepEqual(existing.Spec.Rules, desired.Spec.Rules) ||
+		!equality.Semantic.DeepEqual(existing.Spec.TLS, desired.Spec.TLS)
+}
+
+// ownedServicePorts strips the fields of a service port which we don't set.
+func ownedServicePorts(ports []corev1.ServicePort) []corev1.ServicePort {
+	var owned []corev1.ServicePort
+	for _, p := range ports {
+		owned = append(owned, corev1.ServicePort{
+			Name:       p.Name,
+			Port:       p.Port,
+			TargetPort: p.TargetPort,
+			Protocol:   p.Protocol,
+		})
+	}
+	return owned
+}
+
+// hasLabels reports whether every wanted label is set, users may add their own labels.
+func hasLabels(labels, wanted map[string]string) bool {
+	for k, v := range wanted {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeLabels(labels, wanted map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range wanted {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestIngressNeedsUpdate(t *testing.T) {
+	nginx, traefik := "nginx", "traefik"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		edit        func(ing *networkingv1.Ingress)
+		want        bool
+	}{
+		{
+			name: "in sync",
+			edit: func(ing *networkingv1.Ingress) {},
+		},
+		{
+			name: "defaulted class",
+			edit: func(ing *networkingv1.Ingress) { ing.Spec.IngressClassName = &nginx },
+		},
+		{
+			name: "default backend added by a user",
+			edit: func(ing *networkingv1.Ingress) {
+				ing.Spec.DefaultBackend = &networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{Name: "fallback", Port: networkingv1.ServiceBackendPort{Number: 80}},
+				}
+			},
+		},
+		{
+			name:        "annotated class changed",
+			annotations: enabled(ingressClassAnnotation, nginx),
+			edit:        func(ing *networkingv1.Ingress) { ing.Spec.IngressClassName = &traefik },
+			want:        true,
+		},
+		{
+			name: "host changed",
+			edit: func(ing *networkingv1.Ingress) { ing.Spec.Rules[0].Host = "other.example.com" },
+			want: true,
+		},
+		{
+			name:        "tls removed",
+			annotations: enabled(tlsSecretAnnotation, "web-tls"),
+			edit:        func(ing *networkingv1.Ingress) { ing.Spec.TLS = nil },
+			want:        true,
+		},
+		{
+			name: "label removed",
+			edit: func(ing *networkingv1.Ingress) { delete(ing.Labels, managedByLabel) },
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := tt.annotations
+			if annotations == nil {
+				annotations = enabled()
+			}
+			_, desired := generated(t, newDeployment("web", annotations))
+			existing := desired.DeepCopy()
+			tt.edit(existing)
+
+			if got := ingressNeedsUpdate(existing, desired); got != tt.want {
+				t.Errorf("ingressNeedsUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncIngressKeepsDefaultedClass(t *testing.T) {
+	dep := newDeployment("web", enabled(hostAnnotation, "web.example.com"))
+	svc, ing := generated(t, dep)
+	class := "nginx"
+	ing.Spec.IngressClassName = &class
+	ing.Spec.Rules[0].Host = "other.example.com"
+	f := newFixture(t, dep, svc, ing)
+
+	f.controller.enqueue(dep, "test")
+	f.processItem()
+
+	f.expectActions("update ingresses", "patch deployments")
+	updated, err := f.clientset.NetworkingV1().Ingresses(dep.Namespace).Get(context.Background(), dep.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Spec.Rules[0].Host != "web.example.com" {
+		t.Errorf("host = %q, want web.example.com", updated.Spec.Rules[0].Host)
+	}
+	if updated.Spec.IngressClassName == nil || *updated.Spec.IngressClassName != class {
+		t.Errorf("ingress class = %v, want the defaulted %s kept", updated.Spec.IngressClassName, class)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"strings"
+)
+
+// syncUnstructured creates a resource we only know through the dynamic client, e.g. an HTTPRoute, or updates it
+// when the fields we set have drifted. Events are named after the kind, e.g. HTTPRouteCreated. Going through the
+// dynamic client there is no compile time dependency on the Gateway API or cert-manager types.
+func (c *controller) syncUnstructured(ctx context.Context, dep *appsv1.Deployment, gvr schema.GroupVersionResource, lister cache.GenericLister, desired *unstructured.Unstructured) error {
+	kind := desired.GetKind()
+	resource := strings.ToLower(kind)
+	client := c.dynamicClient.Resource(gvr).Namespace(desired.GetNamespace())
+
+	obj, err := lister.ByNamespace(desired.GetNamespace()).Get(desired.GetName())
+	if errors.IsNotFound(err) {
+		err = c.write("create", nil, desired, func() error {
+			_, err := client.Create(ctx, desired, c.createOptions())
+			return err
+		})
+		switch {
+		case errors.IsAlreadyExists(err):
+			// not cached, see syncService
+			obj, err = client.Get(ctx, desired.GetName(), v1.GetOptions{})
+		case err != nil:
+			return fmt.Errorf("creating %s: %w", resource, err)
+		default:
+			c.recordNormal(ctx, dep, kind+"Created", fmt.Sprintf("created %s %s", resource, desired.GetName()))
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("getting %s: %w", resource, err)
+	}
+
+	existing, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected type %T in %s cache", obj, resource)
+	}
+	if !v1.IsControlledBy(existing, dep) {
+		return fmt.Errorf("%s %s/%s already exists and is not managed by expose", resource, existing.GetNamespace(), existing.GetName())
+	}
+	if !unstructuredNeedsUpdate(existing, desired) {
+		return nil
+	}
+
+	updated := existing.DeepCopy()
+	updated.SetLabels(mergeLabels(updated.GetLabels(), desired.GetLabels()))
+	updated.Object["spec"] = desired.Object["spec"]
+	err = c.write("update", existing, updated, func() error {
+		_, err := client.Update(ctx, updated, c.updateOptions())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", resource, err)
+	}
+	c.recordNormal(ctx, dep, kind+"Updated", fmt.Sprintf("updated drifted %s %s", resource, updated.GetName()))
+	return nil
+}
+
+// deleteOwnedUnstructured removes a resource generated for a deployment, kind is used in events and errors.
+func (c *controller) deleteOwnedUnstructured(ctx context.Context, dep *appsv1.Deployment, gvr schema.GroupVersionResource, lister cache.GenericLister, kind string) error {
+	resource := strings.ToLower(kind)
+	obj, err := lister.ByNamespace(dep.Namespace).Get(dep.Name)
+	if err != nil {
+		return nil
+	}
+	existing, ok := obj.(*unstructured.Unstructured)
+	if !ok || !v1.IsControlledBy(existing, dep) {
+		return nil
+	}
+
+	err = c.write("delete", existing, nil, func() error {
+		return c.dynamicClient.Resource(gvr).Namespace(dep.Namespace).Delete(ctx, dep.Name, c.deleteOptions())
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("deleting %s: %w", resource, err)
+	}
+	c.recordNormal(ctx, dep, kind+"Deleted", fmt.Sprintf("deleted %s %s", resource, dep.Name))
+	return nil
+}
+
+// unstructuredNeedsUpdate compares only the fields we set, the spec of the existing resource may contain fields
+// defaulted by the api server.
+func unstructuredNeedsUpdate(existing, desired *unstructured.Unstructured) bool {
+	return !hasLabels(existing.GetLabels(), desired.GetLabels()) ||
+		!isSubset(desired.Object["spec"], existing.Object["spec"])
+}
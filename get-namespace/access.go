@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// accessLevel is the effective access of the user to a namespace, after the view, edit and admin ClusterRoles.
+type accessLevel string
+
+const (
+	accessNone  accessLevel = "none"
+	accessView  accessLevel = "view"
+	accessEdit  accessLevel = "edit"
+	accessAdmin accessLevel = "admin"
+)
+
+// accessChecks decide the access level, from the highest level down. admin is the only one of the roles which
+// manages RoleBindings, edit changes workloads and view reads them.
+var accessChecks = []struct {
+	level    accessLevel
+	verb     string
+	group    string
+	resource string
+}{
+	{accessAdmin, "create", "rbac.authorization.k8s.io", "rolebindings"},
+	{accessEdit, "create", "apps", "deployments"},
+	{accessView, "list", "", "pods"},
+}
+
+// accessConcurrency limits the reviews sent at once, a review is sent for every namespace.
+const accessConcurrency = 8
+
+const accessTimeout = 10 * time.Second
+
+// accessMsg carries the access levels of namespaces, namespaces whose review failed are left out.
+type accessMsg struct {
+	watcher *namespaceWatcher
+	levels  map[string]accessLevel
+	err     error
+}
+
+// fetchAccess returns a tea.Cmd which reviews the access of the user to namespaces.
+func fetchAccess(w *namespaceWatcher, namespaces []string) tea.Cmd {
+	return func() tea.Msg {
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			levels = map[string]accessLevel{}
+			errs   []error
+		)
+		sem := make(chan struct{}, accessConcurrency)
+		for _, ns := range namespaces {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(ns string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				ctx, cancel := context.WithTimeout(context.Background(), accessTimeout)
+				defer cancel()
+				level, err := namespaceAccess(ctx, w.clientset, ns)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+				levels[ns] = level
+			}(ns)
+		}
+		wg.Wait()
+
+		msg := accessMsg{watcher: w, levels: levels}
+		// one error is enough, they are most likely the same for every namespace
+		if len(errs) > 0 {
+			msg.err = errs[0]
+		}
+		return msg
+	}
+}
+
+// namespaceAccess reviews the access of the user to a namespace. The rules of a SelfSubjectRulesReview are checked
+// first, a single request. They are incomplete when an authorizer, e.g. a webhook, can't list rules, then each check
+// is sent as a SelfSubjectAccessReview.
+func namespaceAccess(ctx context.Context, clientset kubernetes.Interface, namespace string) (accessLevel, error) {
+	review, err := clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}, v1.CreateOptions{})
+	if err == nil && !review.Status.Incomplete {
+		for _, check := range accessChecks {
+			if rulesAllow(review.Status.ResourceRules, check.verb, check.group, check.resource) {
+				return check.level, nil
+			}
+		}
+		return accessNone, nil
+	}
+
+	for _, check := range accessChecks {
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      check.verb,
+					Group:     check.group,
+					Resource:  check.resource,
+				},
+			},
+		}, v1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("reviewing access to %s: %w", namespace, err)
+		}
+		if review.Status.Allowed {
+			return check.level, nil
+		}
+	}
+	return accessNone, nil
+}
+
+// rulesAllow reports whether a verb on all resources of a kind is allowed by the rules, rules limited to
+// resourceNames don't count.
+func rulesAllow(rules []authorizationv1.ResourceRule, verb, group, resource string) bool {
+	for _, r := range rules {
+		if len(r.ResourceNames) > 0 {
+			continue
+		}
+		if matchesRule(r.Verbs, verb) && matchesRule(r.APIGroups, group) && matchesRule(r.Resources, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRule(values []string, value string) bool {
+	return contains(values, "*") || contains(values, value)
+}
+
+// fallbackNamespaces are listed when the user isn't allowed to list namespaces: the namespaces of the kubeconfig
+// contexts of the current cluster, and the favourites.
+func fallbackNamespaces(favourites *favourites) ([]string, error) {
+	config, err := clientConfig().RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+
+	seen := map[string]bool{}
+	var namespaces []string
+	add := func(ns string) {
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if current, ok := config.Contexts[config.CurrentContext]; ok {
+		for _, c := range config.Contexts {
+			if c.Cluster == current.Cluster {
+				add(c.Namespace)
+			}
+		}
+	}
+	for _, ns := range favourites.list() {
+		add(ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
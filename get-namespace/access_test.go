@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+)
+
+func TestRulesAllow(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []authorizationv1.ResourceRule
+		want  bool
+	}{
+		{
+			name: "no rules",
+		},
+		{
+			name:  "exact rule",
+			rules: []authorizationv1.ResourceRule{{Verbs: []string{"get", "create"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}}},
+			want:  true,
+		},
+		{
+			name:  "wildcards",
+			rules: []authorizationv1.ResourceRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}},
+			want:  true,
+		},
+		{
+			name:  "other verb",
+			rules: []authorizationv1.ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}}},
+		},
+		{
+			name:  "other group",
+			rules: []authorizationv1.ResourceRule{{Verbs: []string{"create"}, APIGroups: []string{"extensions"}, Resources: []string{"deployments"}}},
+		},
+		{
+			name: "limited to resourceNames",
+			rules: []authorizationv1.ResourceRule{
+				{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}, ResourceNames: []string{"web"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rulesAllow(tt.rules, "create", "apps", "deployments"); got != tt.want {
+				t.Errorf("rulesAllow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceAccess(t *testing.T) {
+	editRules := []authorizationv1.ResourceRule{
+		{Verbs: []string{"*"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+	}
+	tests := []struct {
+		name string
+		// rules returned by the SelfSubjectRulesReview, the review fails when rulesErr is set
+		rules      []authorizationv1.ResourceRule
+		incomplete bool
+		rulesErr   error
+		// resources allowed by a SelfSubjectAccessReview, every review fails when accessErr is set
+		allowed   map[string]bool
+		accessErr error
+		want      accessLevel
+		// wantReviews is the number of SelfSubjectAccessReviews sent
+		wantReviews int
+		wantErr     bool
+	}{
+		{
+			name:  "complete rules",
+			rules: editRules,
+			want:  accessEdit,
+		},
+		{
+			name: "complete rules without access",
+			want: accessNone,
+		},
+		{
+			name:        "incomplete rules",
+			rules:       editRules,
+			incomplete:  true,
+			allowed:     map[string]bool{"pods": true},
+			want:        accessView,
+			wantReviews: 3,
+		},
+		{
+			name:        "rules review failed",
+			rulesErr:    errors.New("rules review unavailable"),
+			allowed:     map[string]bool{"rolebindings": true},
+			want:        accessAdmin,
+			wantReviews: 1,
+		},
+		{
+			name:        "no access",
+			incomplete:  true,
+			want:        accessNone,
+			wantReviews: 3,
+		},
+		{
+			name:        "access review failed",
+			incomplete:  true,
+			accessErr:   errors.New("forbidden"),
+			wantReviews: 1,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "selfsubjectrulesreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+				if tt.rulesErr != nil {
+					return true, nil, tt.rulesErr
+				}
+				return true, &authorizationv1.SelfSubjectRulesReview{
+					Status: authorizationv1.SubjectRulesReviewStatus{ResourceRules: tt.rules, Incomplete: tt.incomplete},
+				}, nil
+			})
+			reviews := 0
+			clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				reviews++
+				if tt.accessErr != nil {
+					return true, nil, tt.accessErr
+				}
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				review.Status.Allowed = tt.allowed[review.Spec.ResourceAttributes.Resource]
+				return true, review, nil
+			})
+
+			got, err := namespaceAccess(context.Background(), clientset, "web")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("namespaceAccess() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("namespaceAccess() = %q, want %q", got, tt.want)
+			}
+			if reviews != tt.wantReviews {
+				t.Errorf("access reviews = %d, want %d", reviews, tt.wantReviews)
+			}
+		})
+	}
+}
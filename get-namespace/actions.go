@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+const actionTimeout = 10 * time.Second
+
+// Actions which ask for input first, the prompt is shown below the list.
+const (
+	promptCreate = iota
+	promptDelete
+	promptLabel
+)
+
+var promptStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FAAE72"))
+
+// prompt asks for the input of an action on the namespace, e.g. its name to confirm that it should be deleted.
+type prompt struct {
+	action    int
+	namespace string
+	input     textinput.Model
+}
+
+// actionMsg is sent once an action has been done, the list itself is updated through the informer.
+type actionMsg struct {
+	message string
+	err     error
+}
+
+func newPrompt(action int, namespace string) *prompt {
+	input := textinput.New()
+	switch action {
+	case promptCreate:
+		input.Prompt = "New namespace: "
+	case promptDelete:
+		input.Prompt = fmt.Sprintf("Type %q to delete it: ", namespace)
+	case promptLabel:
+		input.Prompt = fmt.Sprintf("Labels of %s (key=value to set, key- to remove): ", namespace)
+	}
+	input.Focus()
+	return &prompt{action: action, namespace: namespace, input: input}
+}
+
+// updatePrompt handles keys while a prompt is shown, esc cancels it.
+func (m Model) updatePrompt(msg tea.KeyMsg) (Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.prompt = nil
+		return m, nil
+	case tea.KeyEnter:
+		p := m.prompt
+		m.prompt = nil
+		value := strings.TrimSpace(p.input.Value())
+		if m.watcher == nil {
+			return m, m.list.NewStatusMessage("Error: not connected to a cluster")
+		}
+		clientset := m.watcher.clientset
+		switch p.action {
+		case promptCreate:
+			if errs := validation.IsDNS1123Label(value); len(errs) > 0 {
+				return m, m.list.NewStatusMessage(fmt.Sprintf("Error: %q is not a valid namespace name: %s", value, strings.Join(errs, ", ")))
+			}
+			return m, createNamespace(clientset, value)
+		case promptDelete:
+			if value != p.namespace {
+				return m, m.list.NewStatusMessage(fmt.Sprintf("Not deleted, %q doesn't match the namespace", value))
+			}
+			return m, deleteNamespace(clientset, p.namespace)
+		case promptLabel:
+			labels, err := parseLabels(value)
+			if err != nil {
+				return m, m.list.NewStatusMessage(fmt.Sprintf("Error: %v", err))
+			}
+			return m, labelNamespace(clientset, p.namespace, labels)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.prompt.input, cmd = m.prompt.input.Update(msg)
+	return m, cmd
+}
+
+func createNamespace(clientset kubernetes.Interface, name string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+		defer cancel()
+		ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: name}}
+		if _, err := clientset.CoreV1().Namespaces().Create(ctx, ns, v1.CreateOptions{}); err != nil {
+			return actionMsg{err: fmt.Errorf("creating namespace: %w", err)}
+		}
+		return actionMsg{message: "Created namespace " + name}
+	}
+}
+
+func deleteNamespace(clientset kubernetes.Interface, name string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+		defer cancel()
+		if err := clientset.CoreV1().Namespaces().Delete(ctx, name, v1.DeleteOptions{}); err != nil {
+			return actionMsg{err: fmt.Errorf("deleting namespace: %w", err)}
+		}
+		return actionMsg{message: fmt.Sprintf("Deleting namespace %s", name)}
+	}
+}
+
+// labelNamespace sets labels with a merge patch, a nil value removes the label.
+func labelNamespace(clientset kubernetes.Interface, name string, labels map[string]*string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+		defer cancel()
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{"labels": labels},
+		})
+		if err != nil {
+			return actionMsg{err: fmt.Errorf("encoding labels: %w", err)}
+		}
+		if _, err := clientset.CoreV1().Namespaces().Patch(ctx, name, types.MergePatchType, patch, v1.PatchOptions{}); err != nil {
+			return actionMsg{err: fmt.Errorf("labelling namespace: %w", err)}
+		}
+		return actionMsg{message: "Labelled namespace " + name}
+	}
+}
+
+// parseLabels parses labels the way kubectl label does, "key=value" sets a label and "key-" removes it.
+func parseLabels(s string) (map[string]*string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no labels given")
+	}
+	labels := map[string]*string{}
+	for _, f := range fields {
+		if key, ok := strings.CutSuffix(f, "-"); ok && !strings.Contains(f, "=") {
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return nil, fmt.Errorf("%q is not a valid label key: %s", key, strings.Join(errs, ", "))
+			}
+			labels[key] = nil
+			continue
+		}
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q must be key=value or key-", f)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("%q is not a valid label key: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("%q is not a valid label value: %s", value, strings.Join(errs, ", "))
+		}
+		labels[key] = &value
+	}
+	return labels, nil
+}
+
+// stuckFinalizers describes what keeps a terminating namespace from being deleted: its finalizers, and the
+// resources which are left with finalizers of their own as reported in the namespace conditions.
+func stuckFinalizers(ns *corev1.Namespace) []string {
+	var stuck []string
+	for _, f := range ns.Spec.Finalizers {
+		stuck = append(stuck, "spec: "+string(f))
+	}
+	for _, f := range ns.Finalizers {
+		stuck = append(stuck, "metadata: "+f)
+	}
+	for _, c := range ns.Status.Conditions {
+		switch c.Type {
+		case corev1.NamespaceContentRemaining, corev1.NamespaceFinalizersRemaining, corev1.NamespaceDeletionContentFailure:
+			if c.Status == corev1.ConditionTrue {
+				stuck = append(stuck, fmt.Sprintf("%s: %s", c.Reason, c.Message))
+			}
+		}
+	}
+	return stuck
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	value := func(s string) *string { return &s }
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]*string
+		wantErr bool
+	}{
+		{
+			name: "set",
+			in:   "team=payments example.com/tier=backend",
+			want: map[string]*string{"team": value("payments"), "example.com/tier": value("backend")},
+		},
+		{
+			name: "empty value",
+			in:   "team=",
+			want: map[string]*string{"team": value("")},
+		},
+		{
+			name: "remove",
+			in:   "team- tier=backend",
+			want: map[string]*string{"team": nil, "tier": value("backend")},
+		},
+		{
+			name:    "nothing given",
+			in:      "  ",
+			wantErr: true,
+		},
+		{
+			name:    "neither set nor removed",
+			in:      "team",
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			in:      "-team=payments",
+			wantErr: true,
+		},
+		{
+			name:    "invalid key removed",
+			in:      "team_-",
+			wantErr: true,
+		},
+		{
+			name:    "value ending in a dash",
+			in:      "team=a-",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			in:      "team=payments!",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabels(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLabels(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabels(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,49 +4,147 @@ import (
 	"context"
 	"fmt"
 	"github.com/charmbracelet/bubbles/list"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/tools/clientcmd"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"sort"
 )
 
-func getNamespaces() []list.Item {
+// clientConfig loads the kubeconfig the way kubectl does, from KUBECONFIG or ~/.kube/config, and is loaded again on
+// every call so a switch of context is picked up.
+func clientConfig() clientcmd.ClientConfig {
+	return contextClientConfig("")
+}
+
+// contextClientConfig is clientConfig for a kubeconfig context, the current context when it is empty.
+func contextClientConfig(kubeContext string) clientcmd.ClientConfig {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	)
+}
 
-	userHomeDir, err := os.UserHomeDir()
+// currentContext returns the current kubeconfig context, favourites are kept per context.
+func currentContext() (string, error) {
+	config, err := clientConfig().RawConfig()
 	if err != nil {
-		fmt.Printf("error getting user home dir: %v\n", err)
-		os.Exit(1)
+		return "", fmt.Errorf("loading kubeconfig: %w", err)
 	}
-	kubeConfigPath := filepath.Join(userHomeDir, ".kube", "config")
+	return config.CurrentContext, nil
+}
 
-	kubeConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+// currentNamespace returns the namespace of the current context, "default" when it isn't set.
+func currentNamespace() (string, error) {
+	namespace, _, err := clientConfig().Namespace()
+	if err != nil {
+		return "", fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	return namespace, nil
+}
 
+// loadCurrentFavourites loads the favourites of the current context.
+func loadCurrentFavourites() (*favourites, error) {
+	kubeContext, err := currentContext()
 	if err != nil {
-		fmt.Printf("Error getting kubernetes config: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
+	return loadFavourites(kubeContext)
+}
 
+// newClientset returns a clientset for a kubeconfig context, the current context when it is empty.
+func newClientset(kubeContext string) (kubernetes.Interface, error) {
+	kubeConfig, err := contextClientConfig(kubeContext).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("getting kubernetes config: %w", err)
+	}
 	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	return clientset, nil
+}
+
+// newMetadataClient returns a client for the metadata of any resource of a kubeconfig context, for when only names or
+// counts are needed.
+func newMetadataClient(kubeContext string) (metadata.Interface, error) {
+	kubeConfig, err := contextClientConfig(kubeContext).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("getting kubernetes config: %w", err)
+	}
+	client, err := metadata.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating metadata client: %w", err)
+	}
+	return client, nil
+}
 
+// listNamespaces lists the namespaces once, sorted by name, for the non-interactive commands.
+func listNamespaces(ctx context.Context, clientset kubernetes.Interface) ([]corev1.Namespace, error) {
+	list, err := clientset.CoreV1().Namespaces().List(ctx, v1.ListOptions{})
 	if err != nil {
-		fmt.Printf("error getting kubernetes config: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("listing namespaces: %w", err)
 	}
+	namespaces := list.Items
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces, nil
+}
 
-	ns, _ := clientset.CoreV1().Namespaces().List(context.TODO(), v1.ListOptions{})
+// switchContext sets the namespace of the current context. The kubeconfig is loaded the way kubectl loads it, so
+// KUBECONFIG with several files is respected and the context is written back to the file it was read from.
+func switchContext(namespace string) error {
+	pathOptions := clientcmd.NewDefaultPathOptions()
+	config, err := pathOptions.GetStartingConfig()
+	if err != nil {
+		return fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return fmt.Errorf("current context %q not found in kubeconfig", config.CurrentContext)
+	}
+	kubeContext.Namespace = namespace
+	if err := clientcmd.ModifyConfig(pathOptions, *config, true); err != nil {
+		return fmt.Errorf("writing kubeconfig: %w", err)
+	}
+	return nil
+}
+
+// getContexts lists the kubeconfig contexts by name.
+func getContexts() ([]list.Item, error) {
+	config, err := clientConfig().RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
 	var items []list.Item
-	for _, nn := range ns.Items {
-		items = append(items, item(nn.Name))
+	for name, c := range config.Contexts {
+		items = append(items, contextItem{
+			name:    name,
+			cluster: c.Cluster,
+			user:    c.AuthInfo,
+			current: name == config.CurrentContext,
+		})
 	}
-	return items
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].(contextItem).name < items[j].(contextItem).name
+	})
+	return items, nil
 }
 
-func switchContext(ctx string) {
-	cmd := exec.Command("kubectl", "config", "set-context", "--current", fmt.Sprintf("--namespace=%s", ctx))
-	err := cmd.Run()
+// useContext makes a context the current context. It is checked first, so that we don't switch to a context whose
+// cluster or user is missing.
+func useContext(name string) error {
+	pathOptions := clientcmd.NewDefaultPathOptions()
+	config, err := pathOptions.GetStartingConfig()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	if err := clientcmd.ConfirmUsable(*config, name); err != nil {
+		return fmt.Errorf("context %q: %w", name, err)
+	}
+	config.CurrentContext = name
+	if err := clientcmd.ModifyConfig(pathOptions, *config, true); err != nil {
+		return fmt.Errorf("writing kubeconfig: %w", err)
 	}
+	return nil
 }
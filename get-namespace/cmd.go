@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"os"
+	"sigs.k8s.io/yaml"
+	"time"
+)
+
+// Values of list --output.
+const (
+	outputJSON = "json"
+	outputYAML = "yaml"
+	outputName = "name"
+)
+
+// namespaceOutput is a namespace as printed by list -o json|yaml.
+type namespaceOutput struct {
+	Name      string `json:"name"`
+	Phase     string `json:"phase,omitempty"`
+	Favourite bool   `json:"favourite"`
+	Current   bool   `json:"current"`
+	// Access is only set when listing namespaces is forbidden.
+	Access accessLevel `json:"access,omitempty"`
+}
+
+// isTerminal reports whether stdout is a terminal, when it isn't, e.g. in a pipe or $(...), plain output is
+// written for scripts.
+func isTerminal() bool {
+	fd := os.Stdout.Fd()
+	return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+}
+
+func newRootCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "get-namespace",
+		Short:        "Switch between kubernetes namespaces and contexts",
+		Long:         "Without a command a Tui is started, or the namespaces are listed by name when stdout isn't a terminal.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !isTerminal() {
+				return runList(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr(), outputName)
+			}
+			return runTUI()
+		},
+	}
+	cmd.AddCommand(newListCmd(), newFavCmd(), newUseCmd(), newCurrentCmd())
+	return cmd
+}
+
+func newListCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List namespaces, the current namespace is marked with * and favourites with ★",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output == "" && !isTerminal() {
+				output = outputName
+			}
+			return runList(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr(), output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output format, json, yaml or name.")
+	return cmd
+}
+
+// runList writes the namespaces to w, warnings go to errW.
+func runList(ctx context.Context, w, errW io.Writer, output string) error {
+	switch output {
+	case "", outputJSON, outputYAML, outputName:
+	default:
+		return fmt.Errorf("%q must be one of %s, %s or %s", output, outputJSON, outputYAML, outputName)
+	}
+
+	clientset, err := newClientset("")
+	if err != nil {
+		return err
+	}
+	favourites, err := loadCurrentFavourites()
+	if err != nil {
+		return err
+	}
+	current, err := currentNamespace()
+	if err != nil {
+		return err
+	}
+
+	var out []namespaceOutput
+	namespaces, err := listNamespaces(ctx, clientset)
+	switch {
+	case errors.IsForbidden(err):
+		// the namespaces of kubeconfig and favourites are listed instead, with the access of the user to each
+		fmt.Fprintln(errW, "Listing namespaces is forbidden, listing namespaces of kubeconfig and favourites")
+		names, err := fallbackNamespaces(favourites)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			access, err := namespaceAccess(ctx, clientset, name)
+			if err != nil {
+				return err
+			}
+			out = append(out, namespaceOutput{
+				Name:      name,
+				Favourite: favourites.contains(name),
+				Current:   name == current,
+				Access:    access,
+			})
+		}
+	case err != nil:
+		return err
+	default:
+		for _, ns := range namespaces {
+			out = append(out, namespaceOutput{
+				Name:      ns.Name,
+				Phase:     string(ns.Status.Phase),
+				Favourite: favourites.contains(ns.Name),
+				Current:   ns.Name == current,
+			})
+		}
+	}
+
+	switch output {
+	case outputJSON:
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding namespaces: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case outputYAML:
+		b, err := yaml.Marshal(out)
+		if err != nil {
+			return fmt.Errorf("encoding namespaces: %w", err)
+		}
+		_, err = w.Write(b)
+		return err
+	case outputName:
+		for _, ns := range out {
+			fmt.Fprintln(w, ns.Name)
+		}
+		return nil
+	}
+
+	for _, ns := range out {
+		marker := " "
+		if ns.Current {
+			marker = "*"
+		}
+		favourite := " "
+		if ns.Favourite {
+			favourite = "★"
+		}
+		if ns.Access != "" {
+			fmt.Fprintf(w, "%s%s %s (%s)\n", marker, favourite, ns.Name, ns.Access)
+			continue
+		}
+		fmt.Fprintf(w, "%s%s %s\n", marker, favourite, ns.Name)
+	}
+	return nil
+}
+
+func newFavCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fav",
+		Short: "Manage the favourite namespaces of the current context",
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "add <namespace>...",
+			Short: "Add namespaces to the favourites",
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				favourites, err := loadCurrentFavourites()
+				if err != nil {
+					return err
+				}
+				for _, ns := range args {
+					if err := favourites.add(ns); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "rm <namespace>...",
+			Short: "Remove namespaces from the favourites",
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				favourites, err := loadCurrentFavourites()
+				if err != nil {
+					return err
+				}
+				for _, ns := range args {
+					if err := favourites.remove(ns); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "ls",
+			Short: "List the favourites",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				favourites, err := loadCurrentFavourites()
+				if err != nil {
+					return err
+				}
+				for _, ns := range favourites.list() {
+					fmt.Fprintln(cmd.OutOrStdout(), ns)
+				}
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+func newUseCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "use <namespace>",
+		Short: "Set the namespace of the current context",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := switchContext(args[0]); err != nil {
+				return err
+			}
+			kubeContext, err := currentContext()
+			if err != nil {
+				return err
+			}
+			history, err := loadHistory(kubeContext)
+			if err == nil {
+				err = history.record(args[0], time.Now())
+			}
+			// the history only ranks namespaces in the Tui, the namespace has been switched
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: recording history: %v\n", err)
+			}
+			if isTerminal() {
+				fmt.Fprintf(cmd.OutOrStdout(), "Active namespace is %q.\n", args[0])
+			}
+			return nil
+		},
+	}
+}
+
+func newCurrentCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "current",
+		Short: "Print the namespace of the current context",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, err := currentNamespace()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), namespace)
+			return nil
+		},
+	}
+}
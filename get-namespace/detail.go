@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// detailDelay is how long a namespace has to stay highlighted before its detail is fetched, scrolling through the
+// list doesn't send a request for every namespace passed.
+const detailDelay = 200 * time.Millisecond
+
+const detailTimeout = 10 * time.Second
+
+var (
+	detailStyle        = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+	detailHeadingStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("170"))
+	detailFaintStyle   = lipgloss.NewStyle().Faint(true)
+)
+
+// namespaceDetail is shown next to the list for the highlighted namespace.
+type namespaceDetail struct {
+	name        string
+	quotas      []string
+	limitRanges []string
+	pods        map[corev1.PodPhase]int
+	deployments int
+	services    int
+	configMaps  int
+}
+
+// detailTickMsg is sent once a namespace has been highlighted for detailDelay.
+type detailTickMsg struct {
+	name string
+}
+
+// detailMsg carries the detail of a namespace, it is dropped when another namespace is highlighted by then.
+type detailMsg struct {
+	detail *namespaceDetail
+	err    error
+}
+
+func waitForDetail(name string) tea.Cmd {
+	return tea.Tick(detailDelay, func(time.Time) tea.Msg {
+		return detailTickMsg{name: name}
+	})
+}
+
+// fetchDetail returns a tea.Cmd which lists the resources of a namespace in parallel. Lists are served from the
+// watch cache of the api server with resourceVersion 0, which is cheaper on big clusters. Resources which are only
+// counted are listed through the metadata client, their specs can be large and aren't needed.
+func fetchDetail(clientset kubernetes.Interface, metadataClient metadata.Interface, name string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), detailTimeout)
+		defer cancel()
+		opts := v1.ListOptions{ResourceVersion: "0"}
+		detail := &namespaceDetail{name: name, pods: map[corev1.PodPhase]int{}}
+
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			errs []error
+		)
+		run := func(resource string, fn func() error) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := fn(); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("listing %s: %w", resource, err))
+					mu.Unlock()
+				}
+			}()
+		}
+
+		run("pods", func() error {
+			pods, err := clientset.CoreV1().Pods(name).List(ctx, opts)
+			if err != nil {
+				return err
+			}
+			for _, pod := range pods.Items {
+				detail.pods[pod.Status.Phase]++
+			}
+			return nil
+		})
+		// counted only, pods are listed in full for their phase
+		count := func(gvr schema.GroupVersionResource, n *int) {
+			run(gvr.Resource, func() error {
+				list, err := metadataClient.Resource(gvr).Namespace(name).List(ctx, opts)
+				if err != nil {
+					return err
+				}
+				*n = len(list.Items)
+				return nil
+			})
+		}
+		count(appsv1.SchemeGroupVersion.WithResource("deployments"), &detail.deployments)
+		count(corev1.SchemeGroupVersion.WithResource("services"), &detail.services)
+		count(corev1.SchemeGroupVersion.WithResource("configmaps"), &detail.configMaps)
+		run("resourcequotas", func() error {
+			quotas, err := clientset.CoreV1().ResourceQuotas(name).List(ctx, opts)
+			if err != nil {
+				return err
+			}
+			detail.quotas = quotaUsage(quotas.Items)
+			return nil
+		})
+		run("limitranges", func() error {
+			limitRanges, err := clientset.CoreV1().LimitRanges(name).List(ctx, opts)
+			if err != nil {
+				return err
+			}
+			detail.limitRanges = limitRangeSummary(limitRanges.Items)
+			return nil
+		})
+		wg.Wait()
+
+		return detailMsg{detail: detail, err: errors.Join(errs...)}
+	}
+}
+
+// quotaUsage returns "quota resource: used/hard" for every resource limited by a quota.
+func quotaUsage(quotas []corev1.ResourceQuota) []string {
+	var usage []string
+	for _, q := range quotas {
+		for _, resource := range sortedResourceNames(q.Status.Hard) {
+			hard := q.Status.Hard[resource]
+			used := q.Status.Used[resource]
+			usage = append(usage, fmt.Sprintf("%s %s: %s/%s", q.Name, resource, used.String(), hard.String()))
+		}
+	}
+	return usage
+}
+
+// limitRangeSummary returns "limitrange type resource: min, max and default" for every resource of a LimitRange.
+func limitRangeSummary(limitRanges []corev1.LimitRange) []string {
+	var summary []string
+	for _, lr := range limitRanges {
+		for _, limit := range lr.Spec.Limits {
+			resources := map[corev1.ResourceName]bool{}
+			for _, l := range []corev1.ResourceList{limit.Min, limit.Max, limit.Default, limit.DefaultRequest} {
+				for resource := range l {
+					resources[resource] = true
+				}
+			}
+			var names []string
+			for resource := range resources {
+				names = append(names, string(resource))
+			}
+			sort.Strings(names)
+
+			for _, resource := range names {
+				var values []string
+				for _, v := range []struct {
+					label string
+					list  corev1.ResourceList
+				}{
+					{"min", limit.Min},
+					{"max", limit.Max},
+					{"default", limit.Default},
+					{"request", limit.DefaultRequest},
+				} {
+					if q, ok := v.list[corev1.ResourceName(resource)]; ok {
+						values = append(values, fmt.Sprintf("%s %s", v.label, q.String()))
+					}
+				}
+				summary = append(summary, fmt.Sprintf("%s %s %s: %s", lr.Name, limit.Type, resource, strings.Join(values, ", ")))
+			}
+		}
+	}
+	return summary
+}
+
+func sortedResourceNames(l corev1.ResourceList) []corev1.ResourceName {
+	var names []corev1.ResourceName
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// renderDetail renders the detail pane, the namespace itself comes from the informer cache and is shown straight
+// away, the counts once they have been fetched.
+func renderDetail(name string, ns *corev1.Namespace, access accessLevel, detail *namespaceDetail, err error, width int) string {
+	if name == "" {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintln(&b, detailHeadingStyle.Render(name))
+	if access != "" {
+		fmt.Fprintf(&b, "Access: %s\n", access)
+	}
+	// namespaces listed from kubeconfig and favourites, when listing namespaces is forbidden, aren't cached
+	if ns == nil {
+		fmt.Fprintln(&b, detailFaintStyle.Render("Namespace can't be read"))
+	} else {
+		fmt.Fprintf(&b, "Phase: %s\n", ns.Status.Phase)
+		fmt.Fprintf(&b, "Age:   %s\n", duration.HumanDuration(time.Since(ns.CreationTimestamp.Time)))
+		writeMap(&b, "Labels", ns.Labels)
+		writeMap(&b, "Annotations", ns.Annotations)
+		if ns.Status.Phase == corev1.NamespaceTerminating {
+			writeList(&b, "Stuck finalizers", stuckFinalizers(ns))
+		}
+	}
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(&b, "\n%s\n", err)
+	case detail == nil:
+		fmt.Fprintf(&b, "\n%s\n", detailFaintStyle.Render("Loading..."))
+	}
+	if detail != nil {
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, detailHeadingStyle.Render("Resources"))
+		fmt.Fprintf(&b, "Pods:        %s\n", podSummary(detail.pods))
+		fmt.Fprintf(&b, "Deployments: %d\n", detail.deployments)
+		fmt.Fprintf(&b, "Services:    %d\n", detail.services)
+		fmt.Fprintf(&b, "ConfigMaps:  %d\n", detail.configMaps)
+		writeList(&b, "Resource quotas", detail.quotas)
+		writeList(&b, "Limit ranges", detail.limitRanges)
+	}
+	return detailStyle.Width(width).Render(strings.TrimRight(b.String(), "\n"))
+}
+
+// podSummary returns the number of pods and how many are in each phase, e.g. "5 (Running 4, Pending 1)".
+func podSummary(pods map[corev1.PodPhase]int) string {
+	total := 0
+	var phases []string
+	for _, phase := range []corev1.PodPhase{corev1.PodRunning, corev1.PodPending, corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown} {
+		if n := pods[phase]; n > 0 {
+			total += n
+			phases = append(phases, fmt.Sprintf("%s %d", phase, n))
+		}
+	}
+	if total == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d (%s)", total, strings.Join(phases, ", "))
+}
+
+func writeMap(b *strings.Builder, heading string, m map[string]string) {
+	var entries []string
+	for k, v := range m {
+		entries = append(entries, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(entries)
+	writeList(b, heading, entries)
+}
+
+func writeList(b *strings.Builder, heading string, entries []string) {
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, detailHeadingStyle.Render(heading))
+	if len(entries) == 0 {
+		fmt.Fprintln(b, detailFaintStyle.Render("none"))
+	}
+	for _, e := range entries {
+		fmt.Fprintln(b, e)
+	}
+}
@@ -5,43 +5,132 @@ import (
 	"strings"
 )
 
-func getFavourites() []string {
-	s := os.Getenv("NS_FAVOURITE_LIST")
-	if len(s) == 0 {
-		_ = os.Setenv("NS_FAVOURITE_LIST", "default")
+// favouritesFile is the content of favourites.yaml, namespaces are favourited per kubeconfig context as the
+// namespaces of a prod cluster differ from those of a dev cluster.
+type favouritesFile struct {
+	Contexts map[string][]string `json:"contexts"`
+}
+
+// favourites are the favourite namespaces of a kubeconfig context, persisted so they are kept between runs.
+type favourites struct {
+	path    string
+	context string
+	names   []string
+}
+
+// loadFavourites reads the favourites of a context. A context without favourites is seeded from the
+// NS_FAVOURITE_LIST env var, a space separated list of namespaces, or "default" when the env var isn't set.
+func loadFavourites(context string) (*favourites, error) {
+	path, err := configPath("favourites.yaml")
+	if err != nil {
+		return nil, err
 	}
+	f := &favourites{path: path, context: context}
 
-	favouriteNamespaces := strings.Fields(os.Getenv("NS_FAVOURITE_LIST"))
-	return favouriteNamespaces
+	unlock, err := lockFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	file, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	f.names = file.namesOf(context)
+	return f, nil
 }
 
-func setFavourites(favourite string) {
-	favourites := getFavourites()
+func (f *favourites) contains(namespace string) bool {
+	return contains(f.names, namespace)
+}
 
-	if !contains(favourites, favourite) {
-		favourites = append(favourites, favourite)
-	} else {
-		for i, v := range favourites {
-			if v == favourite {
-				favourites = removeIndex(favourites, i)
-				break
-			}
+func (f *favourites) list() []string {
+	return f.names
+}
+
+// toggle adds a namespace to the favourites, or removes it when it already is one.
+func (f *favourites) toggle(namespace string) error {
+	return f.update(func(names []string) []string {
+		if contains(names, namespace) {
+			return without(names, namespace)
 		}
+		return append(names, namespace)
+	})
+}
+
+func (f *favourites) add(namespace string) error {
+	return f.update(func(names []string) []string {
+		if contains(names, namespace) {
+			return names
+		}
+		return append(names, namespace)
+	})
+}
+
+func (f *favourites) remove(namespace string) error {
+	return f.update(func(names []string) []string {
+		return without(names, namespace)
+	})
+}
+
+// update changes the favourites of the context. The file is read again while it is locked, so favourites changed
+// by another instance aren't lost.
+func (f *favourites) update(fn func(names []string) []string) error {
+	unlock, err := lockFile(f.path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := f.read()
+	if err != nil {
+		return err
 	}
+	names := fn(file.namesOf(f.context))
 
-	favouritesString := strings.Join(favourites, " ")
-	_ = favouritesString
-	_ = os.Setenv("NS_FAVOURITE_LIST", favouritesString)
+	file.Contexts[f.context] = names
+	if err := f.write(file); err != nil {
+		return err
+	}
+	f.names = names
+	return nil
+}
+
+func (f *favourites) read() (favouritesFile, error) {
+	var file favouritesFile
+	if err := readYAML(f.path, &file); err != nil {
+		return file, err
+	}
+	if file.Contexts == nil {
+		file.Contexts = map[string][]string{}
+	}
+	return file, nil
+}
+
+func (f *favourites) write(file favouritesFile) error {
+	return writeYAML(f.path, file)
+}
+
+// namesOf returns the favourites of a context, seeded when the context has none yet.
+func (file favouritesFile) namesOf(context string) []string {
+	if names, ok := file.Contexts[context]; ok {
+		return append([]string(nil), names...)
+	}
+	seed := strings.Fields(os.Getenv("NS_FAVOURITE_LIST"))
+	if len(seed) == 0 {
+		seed = []string{"default"}
+	}
+	return seed
 }
 
-func isFavourite(namespace string) bool {
-	favourites := getFavourites()
-	for _, v := range favourites {
-		if v == namespace {
-			return true
+func without(s []string, key string) []string {
+	for i, v := range s {
+		if v == key {
+			return removeIndex(s, i)
 		}
 	}
-	return false
+	return s
 }
 
 func removeIndex(s []string, index int) []string {
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/sahilm/fuzzy"
+	"sort"
+	"time"
+)
+
+// historyFile is the content of history.yaml, next to favourites.yaml and like favourites kept per context.
+type historyFile struct {
+	Contexts map[string]map[string]usage `json:"contexts"`
+}
+
+// usage records how often and when a namespace was last switched to.
+type usage struct {
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// history ranks the namespaces of a context by frecency, so the namespaces used most and most recently come first.
+type history struct {
+	path    string
+	context string
+	usage   map[string]usage
+}
+
+func loadHistory(context string) (*history, error) {
+	path, err := configPath("history.yaml")
+	if err != nil {
+		return nil, err
+	}
+	h := &history{path: path, context: context}
+
+	unlock, err := lockFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	file, err := h.read()
+	if err != nil {
+		return nil, err
+	}
+	h.usage = file.Contexts[context]
+	return h, nil
+}
+
+// record counts a switch to a namespace.
+func (h *history) record(namespace string, now time.Time) error {
+	unlock, err := lockFile(h.path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := h.read()
+	if err != nil {
+		return err
+	}
+	if file.Contexts[h.context] == nil {
+		file.Contexts[h.context] = map[string]usage{}
+	}
+	u := file.Contexts[h.context][namespace]
+	u.Count++
+	u.LastUsed = now
+	file.Contexts[h.context][namespace] = u
+
+	if err := writeYAML(h.path, file); err != nil {
+		return err
+	}
+	h.usage = file.Contexts[h.context]
+	return nil
+}
+
+func (h *history) read() (historyFile, error) {
+	var file historyFile
+	if err := readYAML(h.path, &file); err != nil {
+		return file, err
+	}
+	if file.Contexts == nil {
+		file.Contexts = map[string]map[string]usage{}
+	}
+	return file, nil
+}
+
+// frecency weighs the number of switches to a namespace by how recently the last one was, the way z and zoxide do.
+func (h *history) frecency(namespace string, now time.Time) float64 {
+	u, ok := h.usage[namespace]
+	if !ok {
+		return 0
+	}
+	count := float64(u.Count)
+	switch age := now.Sub(u.LastUsed); {
+	case age < time.Hour:
+		return count * 4
+	case age < 24*time.Hour:
+		return count * 2
+	case age < 7*24*time.Hour:
+		return count / 2
+	default:
+		return count / 4
+	}
+}
+
+// sort orders namespaces by frecency, namespaces which were never used keep their order.
+func (h *history) sort(namespaces []string) []string {
+	now := time.Now()
+	sorted := append([]string(nil), namespaces...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return h.frecency(sorted[i], now) > h.frecency(sorted[j], now)
+	})
+	return sorted
+}
+
+// filter is the list.FilterFunc of the namespace views. Namespaces are matched fuzzily and ranked by frecency
+// first, by how well they match second.
+func (h *history) filter(term string, targets []string) []list.Rank {
+	now := time.Now()
+	matches := fuzzy.Find(term, targets)
+	sort.SliceStable(matches, func(i, j int) bool {
+		fi, fj := h.frecency(matches[i].Str, now), h.frecency(matches[j].Str, now)
+		if fi != fj {
+			return fi > fj
+		}
+		return matches[i].Score > matches[j].Score
+	})
+
+	ranks := make([]list.Rank, len(matches))
+	for i, m := range matches {
+		ranks[i] = list.Rank{Index: m.Index, MatchedIndexes: m.MatchedIndexes}
+	}
+	return ranks
+}
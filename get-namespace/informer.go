@@ -0,0 +1,148 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+)
+
+// namespaceWatcher keeps the namespaces of the current context in an informer cache and tells the Model when they
+// change, so the list is updated live and never waits on the api server.
+type namespaceWatcher struct {
+	// kubeContext is the kubeconfig context the watcher was started for
+	kubeContext string
+	clientset   kubernetes.Interface
+	metadata    metadata.Interface
+	lister      listersv1.NamespaceLister
+	changed     chan struct{}
+	errs        chan error
+	stop        chan struct{}
+}
+
+// watcherStartedMsg is sent once the informer of a new watcher is running.
+type watcherStartedMsg struct {
+	watcher *namespaceWatcher
+}
+
+// namespacesMsg carries every namespace after a change, sorted by name.
+type namespacesMsg struct {
+	watcher *namespaceWatcher
+	names   []string
+}
+
+// namespacesErrMsg is sent when the namespaces can't be listed or watched, the informer keeps retrying.
+type namespacesErrMsg struct {
+	watcher *namespaceWatcher
+	err     error
+}
+
+// startNamespaceWatcher returns a tea.Cmd which starts watching the namespaces of a kubeconfig context.
+func startNamespaceWatcher(kubeContext string) tea.Cmd {
+	return func() tea.Msg {
+		clientset, err := newClientset(kubeContext)
+		if err != nil {
+			return namespacesErrMsg{err: err}
+		}
+		metadataClient, err := newMetadataClient(kubeContext)
+		if err != nil {
+			return namespacesErrMsg{err: err}
+		}
+		w := watchNamespaces(clientset)
+		w.kubeContext, w.metadata = kubeContext, metadataClient
+		return watcherStartedMsg{watcher: w}
+	}
+}
+
+// watchNamespaces starts an informer on the namespaces of a cluster.
+func watchNamespaces(clientset kubernetes.Interface) *namespaceWatcher {
+	w := &namespaceWatcher{
+		clientset: clientset,
+		changed:   make(chan struct{}, 1),
+		errs:      make(chan error, 1),
+		stop:      make(chan struct{}),
+	}
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	informer := factory.Core().V1().Namespaces()
+	w.lister = informer.Lister()
+	// handlers only signal a change, the Model reads the whole list from the cache
+	notify := func(any) {
+		if informer.Informer().HasSynced() {
+			w.notify()
+		}
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(old, new any) { notify(new) },
+		DeleteFunc: notify,
+	})
+	// e.g. listing namespaces is forbidden, or the cluster can't be reached
+	_ = informer.Informer().SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		select {
+		case w.errs <- err:
+		default:
+		}
+	})
+
+	factory.Start(w.stop)
+	go func() {
+		if cache.WaitForCacheSync(w.stop, informer.Informer().HasSynced) {
+			w.notify()
+		}
+	}()
+	return w
+}
+
+// notify coalesces changes, a burst of events results in a single update of the list.
+func (w *namespaceWatcher) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// next returns a tea.Cmd which waits for the next change, it has to be returned again after each message.
+func (w *namespaceWatcher) next() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case <-w.changed:
+			return namespacesMsg{watcher: w, names: w.names()}
+		case err := <-w.errs:
+			return namespacesErrMsg{watcher: w, err: err}
+		case <-w.stop:
+			return nil
+		}
+	}
+}
+
+func (w *namespaceWatcher) names() []string {
+	namespaces, _ := w.lister.List(labels.Everything())
+	names := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		names = append(names, ns.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get returns a namespace from the cache, or nil when it doesn't exist or there is no watcher yet.
+func (w *namespaceWatcher) get(name string) *corev1.Namespace {
+	if w == nil {
+		return nil
+	}
+	ns, err := w.lister.Get(name)
+	if err != nil {
+		return nil
+	}
+	return ns
+}
+
+// Stop stops the informer, e.g. when switching to another context.
+func (w *namespaceWatcher) Stop() {
+	close(w.stop)
+}
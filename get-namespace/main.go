@@ -1,26 +1,64 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
+	"io"
+	"k8s.io/klog/v2"
 	"os"
+	"os/signal"
+	"time"
 )
 
 func main() {
-	l := list.New(getNamespaces(), itemDelegate{}, 0, 0)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := newRootCmd().ExecuteContext(ctx); err != nil {
+		// cobra has printed the error
+		stop()
+		os.Exit(1)
+	}
+}
+
+func runTUI() error {
+	// informer errors are shown in the status bar, klog would write over the screen
+	klog.LogToStderr(false)
+	klog.SetOutput(io.Discard)
+
+	kubeContext, err := currentContext()
+	if err != nil {
+		return err
+	}
+	favourites, err := loadFavourites(kubeContext)
+	if err != nil {
+		return fmt.Errorf("loading favourites: %w", err)
+	}
+	history, err := loadHistory(kubeContext)
+	if err != nil {
+		return fmt.Errorf("loading history: %w", err)
+	}
+
+	l := list.New(nil, itemDelegate{favourites: favourites}, 0, 0)
 	l.Title = "[ALL] Namespaces"
+	// errors are shown as status messages, leave them up long enough to be read
+	l.StatusMessageLifetime = 5 * time.Second
+	l.AdditionalFullHelpKeys = additionalKeys
+	l.Filter = history.filter
 	m := Model{
-		list:           l,
-		showFavourites: false,
+		list:        l,
+		favourites:  favourites,
+		history:     history,
+		kubeContext: kubeContext,
+		view:        viewAll,
 	}
 
 	p := tea.NewProgram(m, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
-		fmt.Println("Error running program:", err)
-
-		os.Exit(1)
+		return fmt.Errorf("running program: %w", err)
 	}
+	return nil
 }
 
 func contains(s []string, key string) bool {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofrs/flock"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+)
+
+// configPath returns the path of a file in $XDG_CONFIG_HOME/get-namespace, falling back to ~/.config.
+func configPath(name string) (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("getting user home dir: %w", err)
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "get-namespace", name), nil
+}
+
+// lockFile locks a file in the config dir against other instances, exclusive for a read-modify-write and shared
+// for a read. The lock is held on a separate file, as the file itself is replaced on every write.
+func lockFile(path string, exclusive bool) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating config dir: %w", err)
+	}
+	lock := flock.New(path + ".lock")
+	if exclusive {
+		err = lock.Lock()
+	} else {
+		err = lock.RLock()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	return func() { _ = lock.Unlock() }, nil
+}
+
+// readYAML reads a file in the config dir into v, v is left as is when the file doesn't exist yet.
+func readYAML(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeYAML replaces a file through a rename, so a reader never sees it half written.
+func writeYAML(path string, v any) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
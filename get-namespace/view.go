@@ -2,11 +2,16 @@ package main
 
 import (
 	"fmt"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"strings"
+	"time"
 )
 
 var docStyle = lipgloss.NewStyle().Margin(1, 2)
@@ -22,9 +27,16 @@ var (
 	paginationStyle       = list.DefaultStyles().PaginationStyle.PaddingLeft(4)
 	helpStyle             = list.DefaultStyles().HelpStyle.PaddingLeft(4).PaddingBottom(1)
 	quitTextStyle         = lipgloss.NewStyle().Margin(1, 0, 2, 4)
+	matchedRuneStyle      = lipgloss.NewStyle().Underline(true)
+	accessStyle           = lipgloss.NewStyle().Faint(true)
+	terminatingItemStyle  = lipgloss.NewStyle().PaddingLeft(4).Foreground(lipgloss.Color("241")).Strikethrough(true)
 )
 
-type itemDelegate struct{}
+type itemDelegate struct {
+	favourites *favourites
+	watcher    *namespaceWatcher
+	access     map[string]accessLevel
+}
 
 func (i item) FilterValue() string { return string(i) }
 
@@ -40,7 +52,12 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 	}
 
 	//str := fmt.Sprintf("%d  %s", index+1, i)
-	str := fmt.Sprintf("  %s", i)
+	name := i.String()
+	// the runes matched by the filter are highlighted
+	if matches := m.MatchesForItem(index); len(matches) > 0 {
+		name = lipgloss.StyleRunes(name, matches, matchedRuneStyle, lipgloss.NewStyle())
+	}
+	str := fmt.Sprintf("  %s", name)
 
 	fn := itemStyle.Render
 
@@ -50,7 +67,7 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 		}
 	}
 
-	if isFavourite(i.String()) {
+	if d.favourites.contains(i.String()) {
 		fn = func(s ...string) string {
 			return defaultNamespaceStyle.Render(" ★" + strings.Join(s, " "))
 		}
@@ -61,70 +78,264 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 		}
 	}
 
-	if index == m.Index() && isFavourite(i.String()) {
+	if index == m.Index() && d.favourites.contains(i.String()) {
 		fn = func(s ...string) string {
 			return selectedItemStyle.Render(">★" + strings.Join(s, " "))
 		}
 	}
 
+	if level := d.access[i.String()]; level != "" {
+		str += " " + accessStyle.Render(string(level))
+	}
+
+	if ns := d.watcher.get(i.String()); ns != nil && ns.Status.Phase == corev1.NamespaceTerminating {
+		str += " (Terminating)"
+		if index != m.Index() {
+			fn = terminatingItemStyle.Render
+		}
+	}
+
 	fmt.Fprint(w, fn(str))
 }
 
 type item string
 
+// contextItem is a kubeconfig context, listed in the contexts view.
+type contextItem struct {
+	name    string
+	cluster string
+	user    string
+	current bool
+}
+
+func (c contextItem) FilterValue() string { return c.name }
+
+type contextDelegate struct{}
+
+func (d contextDelegate) Height() int                               { return 1 }
+func (d contextDelegate) Spacing() int                              { return 0 }
+func (d contextDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd { return nil }
+func (d contextDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	c, ok := listItem.(contextItem)
+	if !ok {
+		return
+	}
+
+	str := fmt.Sprintf("%s (cluster: %s, user: %s)", c.name, c.cluster, c.user)
+
+	fn := itemStyle.Render
+	if c.current {
+		fn = func(s ...string) string {
+			return defaultNamespaceStyle.Render("* " + strings.Join(s, " "))
+		}
+	}
+	if index == m.Index() {
+		fn = func(s ...string) string {
+			return selectedItemStyle.Render("> " + strings.Join(s, " "))
+		}
+	}
+
+	fmt.Fprint(w, fn(str))
+}
+
+// Views of the Model, tab cycles through them in this order.
+const (
+	viewAll = iota
+	viewFavourites
+	viewContexts
+	viewCount
+)
+
 type Model struct {
-	list           list.Model
-	showFavourites bool
-	msg            string
-	quitting       bool
+	list       list.Model
+	favourites *favourites
+	history    *history
+	watcher    *namespaceWatcher
+	// kubeContext is the context selected last, the watcher of an earlier one is dropped once it has started
+	kubeContext string
+	namespaces  []string
+	// access of the user to each namespace, an empty level while it is being reviewed
+	access map[string]accessLevel
+	// fallback is set when listing namespaces is forbidden, namespaces then come from kubeconfig and favourites
+	fallback bool
+	view     int
+	width    int
+	height   int
+	// detail of the highlighted namespace, nil until it has been fetched
+	detailFor string
+	detail    *namespaceDetail
+	detailErr error
+	// prompt of an action waiting for input, nil when there is none
+	prompt   *prompt
+	msg      string
+	quitting bool
 }
 
 func (m Model) Init() tea.Cmd {
-	return nil
+	return startNamespaceWatcher(m.kubeContext)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m, cmd := m.update(msg)
+	// the detail of a newly highlighted namespace is fetched once it stays highlighted for a moment
+	if name := m.selectedNamespace(); name != m.detailFor {
+		m.detailFor, m.detail, m.detailErr = name, nil, nil
+		if name != "" {
+			cmd = tea.Batch(cmd, waitForDetail(name))
+		}
+	}
+	return m, cmd
+}
+
+func (m Model) update(msg tea.Msg) (Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.prompt != nil && msg.String() != "ctrl+c" {
+			m, cmd := m.updatePrompt(msg)
+			m.resize()
+			return m, cmd
+		}
+		// keys are typed into the filter
+		if m.list.FilterState() == list.Filtering && msg.String() != "ctrl+c" {
+			break
+		}
 		switch msg.String() {
 		case "ctrl+c":
 			return m, tea.Quit
-		case "tab":
-			m.showFavourites = !m.showFavourites
-			if m.showFavourites {
-				m.list.Title = "[FAVOURITE] Namespaces"
-				m.list.SetItems(getFiltered())
-				return m, nil
-
-			} else {
-				m.list.Title = "[ALL] Namespaces"
-				m.list.SetItems(getNamespaces())
-				return m, nil
+		case "n", "d", "e":
+			if m.view == viewContexts {
+				break
 			}
+			switch msg.String() {
+			case "n":
+				m.prompt = newPrompt(promptCreate, "")
+			case "d", "e":
+				name := m.selectedNamespace()
+				if name == "" {
+					return m, nil
+				}
+				action := promptDelete
+				if msg.String() == "e" {
+					action = promptLabel
+				}
+				m.prompt = newPrompt(action, name)
+			}
+			m.resize()
+			return m, textinput.Blink
+		case "tab":
+			return m, m.showView((m.view + 1) % viewCount)
 		case "f":
+			if m.view == viewContexts {
+				break
+			}
 			item, ok := m.list.SelectedItem().(item)
 			if ok {
-				setFavourites(item.String())
+				if err := m.favourites.toggle(item.String()); err != nil {
+					return m, m.list.NewStatusMessage(fmt.Sprintf("Error saving favourites: %v", err))
+				}
 			}
 
-			if m.list.Title == "[ALL] Namespaces" {
-				m.list.SetItems(getNamespaces())
-			} else {
-				m.list.SetItems(getFiltered())
+			if m.fallback {
+				return m, tea.Batch(m.showFallback(), m.showView(m.view))
 			}
-
-			return m, nil
+			return m, m.showView(m.view)
 		case "enter":
-			item, ok := m.list.SelectedItem().(item)
-			if ok {
-				switchContext(item.String())
+			switch selected := m.list.SelectedItem().(type) {
+			case item:
+				if err := switchContext(selected.String()); err != nil {
+					return m, m.list.NewStatusMessage(fmt.Sprintf("Error switching namespace: %v", err))
+				}
+				// the history only ranks namespaces, failing to record it doesn't fail the switch
+				_ = m.history.record(selected.String(), time.Now())
 				return m, tea.Quit
+			case contextItem:
+				return m, m.selectContext(selected.name)
 			}
 		}
 
+	case watcherStartedMsg:
+		// switching context twice in a row starts two watchers, only the one of the context selected last is kept
+		if msg.watcher.kubeContext != m.kubeContext {
+			msg.watcher.Stop()
+			return m, nil
+		}
+		if m.watcher != nil && m.watcher != msg.watcher {
+			m.watcher.Stop()
+		}
+		m.watcher = msg.watcher
+		m.access, m.fallback = map[string]accessLevel{}, false
+		if m.view != viewContexts {
+			m.list.SetDelegate(m.namespaceDelegate())
+		}
+		return m, tea.Batch(m.list.StartSpinner(), m.watcher.next())
+
+	case namespacesMsg:
+		// a message of the watcher of a previous context
+		if msg.watcher != m.watcher {
+			return m, nil
+		}
+		m.list.StopSpinner()
+		m.namespaces, m.fallback = msg.names, false
+		var cmd tea.Cmd
+		if m.view != viewContexts {
+			cmd = m.showView(m.view)
+		}
+		return m, tea.Batch(cmd, m.reviewAccess(), m.watcher.next())
+
+	case namespacesErrMsg:
+		if msg.watcher != m.watcher {
+			return m, nil
+		}
+		m.list.StopSpinner()
+		if m.watcher == nil {
+			return m, m.list.NewStatusMessage(fmt.Sprintf("Error watching namespaces: %v", msg.err))
+		}
+		// the informer keeps retrying, the namespaces are listed once the user is allowed to
+		if errors.IsForbidden(msg.err) && len(m.namespaces) == 0 && !m.fallback {
+			m.fallback = true
+			status := m.list.NewStatusMessage("Listing namespaces is forbidden, showing namespaces of kubeconfig and favourites")
+			return m, tea.Batch(status, m.showFallback(), m.watcher.next())
+		}
+		if m.fallback {
+			return m, m.watcher.next()
+		}
+		return m, tea.Batch(m.list.NewStatusMessage(fmt.Sprintf("Error watching namespaces: %v", msg.err)), m.watcher.next())
+
+	case accessMsg:
+		if msg.watcher != m.watcher {
+			return m, nil
+		}
+		for ns, level := range msg.levels {
+			m.access[ns] = level
+		}
+		if msg.err != nil {
+			return m, m.list.NewStatusMessage(fmt.Sprintf("Error reviewing access: %v", msg.err))
+		}
+		return m, nil
+
+	case actionMsg:
+		if msg.err != nil {
+			return m, m.list.NewStatusMessage(fmt.Sprintf("Error: %v", msg.err))
+		}
+		return m, m.list.NewStatusMessage(msg.message)
+
+	case detailTickMsg:
+		if msg.name != m.detailFor || m.watcher == nil {
+			return m, nil
+		}
+		return m, fetchDetail(m.watcher.clientset, m.watcher.metadata, msg.name)
+
+	case detailMsg:
+		if msg.detail.name != m.detailFor {
+			return m, nil
+		}
+		m.detail, m.detailErr = msg.detail, msg.err
+		return m, nil
+
 	case tea.WindowSizeMsg:
 		h, v := docStyle.GetFrameSize()
-		m.list.SetSize(msg.Width-h, msg.Height-v)
+		m.width, m.height = msg.Width-h, msg.Height-v
+		m.resize()
 	}
 
 	var cmd tea.Cmd
@@ -132,20 +343,158 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// showView switches to a view and loads its items.
+func (m *Model) showView(view int) tea.Cmd {
+	m.view = view
+	m.resize()
+	switch view {
+	case viewFavourites:
+		m.list.Title = "[FAVOURITE] Namespaces"
+		m.list.SetDelegate(m.namespaceDelegate())
+		m.list.Filter = m.history.filter
+		return m.list.SetItems(getFiltered(m.history.sort(m.namespaces), m.favourites))
+	case viewContexts:
+		m.list.Title = "[CONTEXTS] Kube contexts"
+		m.list.SetDelegate(contextDelegate{})
+		m.list.Filter = list.DefaultFilter
+		contexts, err := getContexts()
+		if err != nil {
+			return tea.Batch(m.list.SetItems(nil), m.list.NewStatusMessage(fmt.Sprintf("Error listing contexts: %v", err)))
+		}
+		return m.list.SetItems(contexts)
+	default:
+		m.list.Title = "[ALL] Namespaces"
+		m.list.SetDelegate(m.namespaceDelegate())
+		m.list.Filter = m.history.filter
+		return m.list.SetItems(namespaceItems(m.history.sort(m.namespaces)))
+	}
+}
+
+func (m Model) namespaceDelegate() itemDelegate {
+	return itemDelegate{favourites: m.favourites, watcher: m.watcher, access: m.access}
+}
+
+// showFallback lists the namespaces of kubeconfig and favourites, when listing namespaces is forbidden.
+func (m *Model) showFallback() tea.Cmd {
+	namespaces, err := fallbackNamespaces(m.favourites)
+	if err != nil {
+		return m.list.NewStatusMessage(fmt.Sprintf("Error listing namespaces: %v", err))
+	}
+	m.namespaces = namespaces
+	var cmd tea.Cmd
+	if m.view != viewContexts {
+		cmd = m.showView(m.view)
+	}
+	return tea.Batch(cmd, m.reviewAccess())
+}
+
+// reviewAccess reviews the access of the user to the namespaces which haven't been reviewed yet. Reviews are sent
+// through the clientset of the watcher, there is none while switching context.
+func (m *Model) reviewAccess() tea.Cmd {
+	if m.watcher == nil {
+		return nil
+	}
+	var namespaces []string
+	for _, ns := range m.namespaces {
+		if _, ok := m.access[ns]; !ok {
+			m.access[ns] = ""
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil
+	}
+	return fetchAccess(m.watcher, namespaces)
+}
+
+// selectContext switches the current context, then shows the namespaces, favourites and history of its cluster.
+func (m *Model) selectContext(name string) tea.Cmd {
+	if err := useContext(name); err != nil {
+		return m.list.NewStatusMessage(fmt.Sprintf("Error switching context: %v", err))
+	}
+	favourites, err := loadFavourites(name)
+	if err != nil {
+		return m.list.NewStatusMessage(fmt.Sprintf("Error loading favourites: %v", err))
+	}
+	history, err := loadHistory(name)
+	if err != nil {
+		return m.list.NewStatusMessage(fmt.Sprintf("Error loading history: %v", err))
+	}
+	m.kubeContext, m.favourites, m.history = name, favourites, history
+
+	// the namespaces are listed again once the watcher of the new cluster has synced
+	if m.watcher != nil {
+		m.watcher.Stop()
+		m.watcher = nil
+	}
+	// the access and whether listing namespaces is forbidden are reviewed again for the new cluster
+	m.namespaces, m.access, m.fallback = nil, map[string]accessLevel{}, false
+	return tea.Batch(m.showView(viewAll), startNamespaceWatcher(name), m.list.NewStatusMessage("Switched to context "+name))
+}
+
+// resize gives the list half of the window when the detail pane is shown next to it, and leaves a line for the
+// prompt.
+func (m *Model) resize() {
+	height := m.height
+	if m.prompt != nil {
+		height--
+	}
+	if m.view == viewContexts {
+		m.list.SetSize(m.width, height)
+		return
+	}
+	m.list.SetSize(m.width/2, height)
+}
+
+// selectedNamespace returns the highlighted namespace, or an empty string in the contexts view.
+func (m Model) selectedNamespace() string {
+	if m.view == viewContexts {
+		return ""
+	}
+	item, ok := m.list.SelectedItem().(item)
+	if !ok {
+		return ""
+	}
+	return item.String()
+}
+
 func (m Model) View() string {
-	return docStyle.Render(m.list.View())
+	view := m.list.View()
+	if m.view != viewContexts && m.watcher != nil {
+		// the border of the pane is outside of its width
+		detail := renderDetail(m.detailFor, m.watcher.get(m.detailFor), m.access[m.detailFor], m.detail, m.detailErr, m.width-m.list.Width()-2)
+		view = lipgloss.JoinHorizontal(lipgloss.Top, view, lipgloss.NewStyle().MaxHeight(m.list.Height()).Render(detail))
+	}
+	if m.prompt != nil {
+		view = lipgloss.JoinVertical(lipgloss.Left, view, promptStyle.Render(m.prompt.input.View()))
+	}
+	return docStyle.Render(view)
+}
 
+// additionalKeys are shown in the help of the list.
+func additionalKeys() []key.Binding {
+	return []key.Binding{
+		key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "next view")),
+		key.NewBinding(key.WithKeys("f"), key.WithHelp("f", "favourite")),
+		key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "new")),
+		key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "delete")),
+		key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "edit labels")),
+	}
 }
 
-func getFiltered() []list.Item {
-	unfilteredNamespaces := getNamespaces()
-	favouriteNamespaces := getFavourites()
+func namespaceItems(namespaces []string) []list.Item {
+	var items []list.Item
+	for _, ns := range namespaces {
+		items = append(items, item(ns))
+	}
+	return items
+}
+
+func getFiltered(namespaces []string, favourites *favourites) []list.Item {
 	var filteredItems []list.Item
-	for _, ns := range unfilteredNamespaces {
-		for _, f := range favouriteNamespaces {
-			if f == ns.(item).String() {
-				filteredItems = append(filteredItems, ns)
-			}
+	for _, ns := range namespaces {
+		if favourites.contains(ns) {
+			filteredItems = append(filteredItems, item(ns))
 		}
 	}
 	return filteredItems